@@ -0,0 +1,208 @@
+package fileupload
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors" // external dependency
+)
+
+var ErrInvalidFileName = errors.New("The file name is not valid!")
+var ErrFileDoesNotExist = errors.New("The file does not exist!")
+
+/*
+	Names given by a client have to be a plain file name in the directory
+	Paths ("../x", "a/b"), hidden files (the state directory, temporary uploads) and control characters are refused
+*/
+func checkFileName(name string) error {
+	if len(name) == 0 || len(name) > 255 || name[0] == '.' {
+		return ErrInvalidFileName
+	}
+	if strings.ContainsAny(name, `/\`) || strings.ContainsRune(name, os.PathSeparator) {
+		return ErrInvalidFileName
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return ErrInvalidFileName
+		}
+	}
+	return nil
+}
+
+/*
+	Delete an uploaded file, the storage quota of its owner is given back, see ReleaseQuota()
+	Returns ErrInvalidFileName for names that are not a file in the directory and ErrFileDoesNotExist
+*/
+func DeleteFile(directory, name string) error {
+	if err := deleteFile(directory, name); err != nil {
+		return err
+	}
+	DefaultHooks.afterDelete(directory, name)
+	return nil
+}
+
+// same as DeleteFile(), without calling the hooks
+func deleteFile(directory, name string) error {
+	root, err := OpenRoot(directory) // does the directory exist?
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	if err := checkFileName(name); err != nil {
+		return err
+	}
+
+	fi, err := root.Lstat(name)
+	if os.IsNotExist(err) {
+		return ErrFileDoesNotExist
+	}
+	if err != nil {
+		return errors.Wrap(err, "deleteFile()")
+	}
+	if fi.IsDir() {
+		return ErrInvalidFileName
+	}
+
+	if err := root.Remove(name); err != nil { // a symbolic link is removed, not the file it points to
+		return errors.Wrap(err, "deleteFile()")
+	}
+	if err := ReleaseQuota(directory, name); err != nil {
+		return errors.Wrap(err, "deleteFile()")
+	}
+
+	return nil
+}
+
+// delete an image saved by UploadImageWithThumbnail(), with its thumbnail and the archived original of DefaultImageOptions.ArchiveDir
+func DeleteImage(imageDir, thumbnailDir, name string) error {
+	return DeleteImageWithOptions(imageDir, thumbnailDir, name, DefaultImageOptions)
+}
+
+/*
+	Delete an image saved by UploadImageWithOptions() and every file made from it
+	The thumbnail and the archived original have the same UUID as the image, missing ones are skipped
+	The jobs of the image in options.Jobs are removed, see JobQueue.Cancel()
+*/
+func DeleteImageWithOptions(imageDir, thumbnailDir, name string, options ImageOptions) error {
+	thumbnailRoot, err := OpenRoot(thumbnailDir)
+	if err != nil {
+		return err
+	}
+	defer thumbnailRoot.Close()
+	if err := deleteFile(imageDir, name); err != nil {
+		return err
+	}
+	defer DefaultHooks.afterDelete(imageDir, name) // the image is gone even if the other files can not be deleted
+	if options.Jobs != nil {                       // a pending thumbnail job would make the thumbnail again
+		if err := options.Jobs.Cancel(name); err != nil {
+			return errors.Wrap(err, "DeleteImageWithOptions()")
+		}
+	}
+
+	uuidBase := strings.TrimSuffix(name, filepath.Ext(name))
+	if err := thumbnailRoot.Remove(uuidBase + ".jpg"); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "DeleteImageWithOptions()")
+	}
+	if len(options.ArchiveDir) > 0 { // the original extension is not known, the name without it has to be the same
+		archiveRoot, err := OpenRoot(options.ArchiveDir)
+		if err != nil {
+			return err
+		}
+		defer archiveRoot.Close()
+		entries, err := archiveRoot.Readdir()
+		if err != nil {
+			return errors.Wrap(err, "DeleteImageWithOptions()")
+		}
+		for _, entry := range entries {
+			if strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())) != uuidBase { // "photo.backup.png" is the original of "photo.backup.jpg"
+				continue
+			}
+			if err := archiveRoot.Remove(entry.Name()); err != nil && !os.IsNotExist(err) {
+				return errors.Wrap(err, "DeleteImageWithOptions()")
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+	Delete several files, every name is tried even after an error
+	Returns one FileInfo for each name, Error is set for the files that were not deleted
+*/
+func DeleteAllFiles(directory string, names []string) ([]FileInfo, error) {
+	return deleteAll(names, directory, func(name string) error {
+		return DeleteFile(directory, name)
+	})
+}
+
+// same as DeleteAllFiles() for images, see DeleteImage()
+func DeleteAllImages(imageDir, thumbnailDir string, names []string) ([]FileInfo, error) {
+	return deleteAll(names, imageDir, func(name string) error {
+		return DeleteImage(imageDir, thumbnailDir, name)
+	})
+}
+
+func deleteAll(names []string, directory string, deleteFunc func(name string) error) ([]FileInfo, error) {
+	var slice []FileInfo
+	var failed int
+
+	for _, name := range names {
+		fi := FileInfo{Name: name, Directory: directory}
+		if err := deleteFunc(name); err != nil {
+			fi.Error = err.Error()
+			failed++
+		}
+		slice = append(slice, fi)
+	}
+
+	if failed > 0 {
+		return slice, errors.Errorf("deleteAll(): %d of %d files were not deleted", failed, len(names))
+	}
+	return slice, nil
+}
+
+/*
+	Handler for the DeleteUrl of FileInfo, the name of the file is in the "file" parameter of the URL
+	Accepts the DELETE method, or POST with "_method=DELETE" for forms without javascript (DeleteNoJSUrl)
+	A "file" in a POST body is ignored, it could replace the name of a URL signed by URLSigner.Middleware()
+	deleteFunc - DeleteFile() or DeleteImage() for the directories of the application, checking the user is up to the caller
+	Example:
+	http.Handle("/delete", fileupload.DeleteHandler(func(name string) error { return fileupload.DeleteImage("images", "thumbnails", name) }))
+*/
+func DeleteHandler(deleteFunc func(name string) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" && (r.Method != "POST" || r.FormValue("_method") != "DELETE") {
+			w.Header().Set("Allow", "DELETE, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := r.URL.Query().Get("file")
+		err := deleteFunc(name)
+		switch errors.Cause(err) {
+		case nil:
+		case ErrInvalidFileName:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case ErrFileDoesNotExist:
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		default:
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		// the response of the jQuery File Upload plugin: {"files": [{"name": true}]}
+		b, err := json.Marshal(map[string][]map[string]bool{"files": {{name: true}}})
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+}
@@ -0,0 +1,162 @@
+package fileupload
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_checkFileName(t *testing.T) {
+	var list = []struct {
+		name  string
+		valid bool
+	}{
+		{"6ba7b810-9dad-11d1-80b4-00c04fd430c8.jpg", true},
+		{"car.tar.gz", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../etc/passwd", false},
+		{"a/b.jpg", false},
+		{`..\b.jpg`, false},
+		{".state", false},
+		{"a\x00b", false},
+		{"a\nb", false},
+	}
+	for _, l := range list {
+		if err := checkFileName(l.name); (err == nil) != l.valid {
+			t.Errorf("checkFileName(%q): Returned[%v]. Expected valid[%t]", l.name, err, l.valid)
+		}
+	}
+}
+
+func TestDeleteFile(t *testing.T) {
+	tempDir := "testing-filevalidator"
+	dir, err := ioutil.TempDir("", tempDir) // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	req := setupRequestMultipartForm(&testFile{carTARGZ, "fileupload", "car.tar.gz"}, &testFile{blueJPG, "fileupload", "blue.jpg"})
+	fis, err := UploadAllFiles(req.MultipartForm.File, dir, true)
+	if err != nil {
+		t.Fatalf("UploadAllFiles(): Returned an error! [%s]", err)
+	}
+
+	if err := DeleteFile(dir, fis[0].Name); err != nil {
+		t.Errorf("DeleteFile(): Returned an error! [%s]", err)
+	}
+	if _, err := os.Stat(dir + string(os.PathSeparator) + fis[0].Name); !os.IsNotExist(err) {
+		t.Errorf("DeleteFile(): The file was not deleted!")
+	}
+	if err := DeleteFile(dir, fis[0].Name); err != ErrFileDoesNotExist {
+		t.Errorf("DeleteFile(): Should return ErrFileDoesNotExist! [%v]", err)
+	}
+	if err := DeleteFile(dir, "../"+fis[1].Name); err != ErrInvalidFileName {
+		t.Errorf("DeleteFile(): Should return ErrInvalidFileName! [%v]", err)
+	}
+
+	deleted, err := DeleteAllFiles(dir, []string{fis[1].Name, "missing.txt"})
+	if err == nil {
+		t.Errorf("DeleteAllFiles(): Should return an error for missing.txt!")
+	}
+	if len(deleted) != 2 || deleted[0].Error != "" || deleted[1].Error != ErrFileDoesNotExist.Error() {
+		t.Errorf("DeleteAllFiles(): Returned[%+v]", deleted)
+	}
+	if files := uploadDirEntries(t, dir); len(files) != 0 {
+		t.Errorf("DeleteAllFiles(): Files were not deleted! [%d]", len(files))
+	}
+}
+
+func TestDeleteImage(t *testing.T) {
+	tempDir1, tempDir2, tempDir3 := "testing-filevalidator-images", "testing-filevalidator-thumbnails", "testing-filevalidator-archive"
+	dir1, err := ioutil.TempDir("", tempDir1) // make three temporary directories
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir2, err := ioutil.TempDir("", tempDir2)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir3, err := ioutil.TempDir("", tempDir3)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir1) // delete the temp directories
+	defer os.RemoveAll(dir2)
+	defer os.RemoveAll(dir3)
+
+	options := ImageOptions{ArchiveDir: dir3}
+	req := setupRequestMultipartForm(&testFile{gopherPNG, "imageupload", "gopher.png"})
+	fi, err := UploadImageWithOptions(req.MultipartForm.File["imageupload"][0], dir1, dir2, options)
+	if err != nil {
+		t.Fatalf("UploadImageWithOptions(): Returned an error! [%s]", err)
+	}
+
+	// the archived original of another image with a longer name is kept
+	other := strings.TrimSuffix(fi.Name, ".jpg") + ".backup.png"
+	if err := ioutil.WriteFile(dir3+string(os.PathSeparator)+other, []byte("another original"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %s", err)
+	}
+
+	if err := DeleteImageWithOptions(dir1, dir2, fi.Name, options); err != nil {
+		t.Errorf("DeleteImageWithOptions(): Returned an error! [%s]", err)
+	}
+	for _, dir := range []string{dir1, dir2} {
+		if files := uploadDirEntries(t, dir); len(files) != 0 {
+			t.Errorf("DeleteImageWithOptions(): Files were left in [%s]! [%d]", dir, len(files))
+		}
+	}
+	if files := uploadDirEntries(t, dir3); len(files) != 1 || files[0].Name() != other {
+		t.Errorf("DeleteImageWithOptions(): Wrong archived originals deleted! [%d left]", len(files))
+	}
+}
+
+func TestDeleteHandler(t *testing.T) {
+	var deleted []string
+	handler := DeleteHandler(func(name string) error {
+		if err := checkFileName(name); err != nil {
+			return err
+		}
+		if name == "missing.txt" {
+			return ErrFileDoesNotExist
+		}
+		deleted = append(deleted, name)
+		return nil
+	})
+
+	var list = []struct {
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{"DELETE", "/delete?file=a.jpg", "", http.StatusOK},
+		{"POST", "/delete?file=b.jpg", "_method=DELETE", http.StatusOK},
+		{"POST", "/delete", "_method=DELETE&file=b.jpg", http.StatusBadRequest}, // only the name in the URL is used
+		{"POST", "/delete?file=f.jpg", "file=victim.txt&_method=DELETE", http.StatusOK},
+		{"GET", "/delete?file=c.jpg", "", http.StatusMethodNotAllowed},
+		{"POST", "/delete", "file=d.jpg", http.StatusMethodNotAllowed},
+		{"DELETE", "/delete?file=..%2Fe.jpg", "", http.StatusBadRequest},
+		{"DELETE", "/delete?file=missing.txt", "", http.StatusNotFound},
+	}
+	for _, l := range list {
+		req := httptest.NewRequest(l.method, l.target, strings.NewReader(l.body))
+		if len(l.body) > 0 {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != l.status {
+			t.Errorf("DeleteHandler(%s %s %s): Returned[%d]. Expected[%d]", l.method, l.target, l.body, w.Code, l.status)
+		}
+	}
+
+	if len(deleted) != 3 || deleted[0] != "a.jpg" || deleted[1] != "b.jpg" || deleted[2] != "f.jpg" {
+		t.Errorf("DeleteHandler(): Deleted the wrong files! [%v]", deleted)
+	}
+}
@@ -0,0 +1,132 @@
+package fileupload
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors" // external dependency
+)
+
+/*
+	Extra information for directory listings, each one costs more reads of the files
+
+	ImageSize - read the image headers for Width, Height, Frames and Duration, the pixels are not decoded. Also sets MimeType and IsImage
+	Times - set ModTime. The creation time is not set, it can not be read through os.FileInfo on every system
+	ThumbnailDir - set ThumbnailName of the images that have a thumbnail in this directory, see UploadImageWithThumbnail()
+	URLBuilder - fill in the URLs of each file after the other details, ThumbnailUrl comes from its ThumbnailURL. DefaultURLBuilder when nil
+	SetURLs - fill in the URLs of each file instead of URLBuilder
+*/
+type ListingDetails struct {
+	ImageSize    bool
+	Times        bool
+	ThumbnailDir string
+	URLBuilder   *URLBuilder
+	SetURLs      func(fi *FileInfo)
+}
+
+// the Name and os.FileInfo of a listed file in its Root, kept to fill in details
+type listedFile struct {
+	name string
+	info os.FileInfo
+}
+
+/*
+	Fill in the details of fis, files[i] is fis[i] in the root
+	Files that can not be read have Error set, only a missing ThumbnailDir fails the listing
+*/
+func (d ListingDetails) fill(root *Root, files []listedFile, fis []FileInfo) error {
+	var thumbnails *Root
+	if len(d.ThumbnailDir) > 0 {
+		var err error
+		if thumbnails, err = OpenRoot(d.ThumbnailDir); err != nil {
+			return err
+		}
+		defer thumbnails.Close()
+	}
+
+	if d.Times {
+		for i, f := range files {
+			modTime := f.info.ModTime()
+			fis[i].ModTime = &modTime
+		}
+	}
+
+	if d.ImageSize {
+		inParallel(len(fis), func(i int) {
+			if len(fis[i].Error) == 0 {
+				if err := readImageDetails(root, files[i].name, &fis[i]); err != nil {
+					fis[i].Error = errors.Cause(err).Error()
+				}
+			}
+		})
+	}
+
+	if thumbnails != nil {
+		for i := range fis {
+			thumbnail := strings.TrimSuffix(fis[i].Name, filepath.Ext(fis[i].Name)) + ".jpg" // see UploadImageWithOptions()
+			if fi, err := thumbnails.Stat(thumbnail); err != nil || fi.IsDir() {
+				continue
+			}
+			fis[i].ThumbnailName = thumbnail
+		}
+	}
+
+	setURLs := d.SetURLs
+	if setURLs == nil && d.URLBuilder != nil {
+		setURLs = d.URLBuilder.Set
+	}
+	for i := range fis {
+		setFileURLs(&fis[i], setURLs)
+	}
+
+	return nil
+}
+
+// the mimetype and, for images, the header of a file
+func readImageDetails(root *Root, name string, fi *FileInfo) error {
+	file, err := root.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if len(fi.MimeType) == 0 {
+		if fi.MimeType, err = getMimeType(file); err != nil {
+			return err
+		}
+		fi.IsImage = isFileImage(fi.MimeType)
+	}
+	if !fi.IsImage {
+		return nil
+	}
+
+	h, err := readImageHeader(file, DefaultImageLimits.MaxFrames)
+	if err != nil {
+		return err
+	}
+	fi.Width, fi.Height, fi.Frames, fi.Duration = h.Width, h.Height, h.Frames, h.Duration
+	return nil
+}
+
+// call do(0) to do(n-1), on up to mimeTypeWorkers goroutines at once
+func inParallel(n int, do func(i int)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < mimeTypeWorkers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				do(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
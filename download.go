@@ -0,0 +1,198 @@
+package fileupload
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors" // external dependency
+)
+
+// types a browser may show, every other type is downloaded
+var inlineMimeTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp", "image/bmp", "video/mp4", "video/webm", "audio/mpeg", "audio/ogg", "text/plain"}
+
+// finds the file for a name given by a client, see LookupFile()
+type FileLookup func(name string) (*FileInfo, error)
+
+/*
+	Information about a saved file for serving it
+	The OriginalName and the owner are known for files with a record (Quarantine, owner), otherwise the mimetype is read from the file
+	Returns ErrInvalidFileName, ErrFileDoesNotExist and ErrPathEscapesRoot
+*/
+func LookupFile(directory, name string) (*FileInfo, error) {
+	root, err := OpenRoot(directory)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	if err := checkFileName(name); err != nil {
+		return nil, err
+	}
+
+	stat, err := root.Stat(name)
+	if err == ErrPathEscapesRoot {
+		return nil, err
+	}
+	if err != nil || !stat.Mode().IsRegular() {
+		return nil, ErrFileDoesNotExist
+	}
+
+	record, err := readUploadRecord(directory, name)
+	if err == nil {
+		if record.State != StateApproved { // not reviewed yet
+			return nil, ErrFileDoesNotExist
+		}
+		fi := record.fileInfo()
+		fi.Directory, fi.Size = directory, stat.Size()
+		return fi, nil
+	}
+	if err != ErrUploadNotFound {
+		return nil, errors.Wrap(err, "LookupFile()")
+	}
+
+	file, err := root.Open(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "LookupFile()")
+	}
+	defer file.Close()
+	mimetype, err := getMimeType(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "LookupFile()")
+	}
+
+	return &FileInfo{Name: name, Size: stat.Size(), Directory: directory, MimeType: mimetype, IsImage: isFileImage(mimetype), State: StateApproved}, nil
+}
+
+// a FileLookup for the files of one directory
+func DirectoryLookup(directory string) FileLookup {
+	return func(name string) (*FileInfo, error) {
+		return LookupFile(directory, name)
+	}
+}
+
+/*
+	Serve uploaded files, the name of the file is in the "file" parameter of the URL
+	Range requests, ETag and If-Modified-Since are handled by http.ServeContent()
+	Files are never shown as HTML: X-Content-Type-Options is nosniff, a sandbox Content-Security-Policy is set and every type
+	that is not on the inline list (images, audio, video, plain text) is sent as an attachment named after the sanitized OriginalName
+	The bandwidth is limited by DefaultThrottle, by the IP address of the client unless DefaultThrottle.KeyFunc is set
+	Example:
+	http.Handle("/download", fileupload.DownloadHandler(fileupload.DirectoryLookup("uploads")))
+*/
+func DownloadHandler(lookup FileLookup) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		fi, err := lookup(r.URL.Query().Get("file"))
+		if err != nil {
+			switch errors.Cause(err) {
+			case ErrInvalidFileName:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			case ErrFileDoesNotExist, ErrPathEscapesRoot:
+				http.Error(w, ErrFileDoesNotExist.Error(), http.StatusNotFound)
+			default:
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			}
+			return
+		}
+
+		root, err := OpenRoot(fi.Directory)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		defer root.Close()
+		file, err := root.Open(fi.Name)
+		if err != nil {
+			http.Error(w, ErrFileDoesNotExist.Error(), http.StatusNotFound)
+			return
+		}
+		defer file.Close()
+		stat, err := file.Stat()
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		header := w.Header()
+		for key, value := range downloadHeaders(fi) {
+			header.Set(key, value)
+		}
+		header.Set("ETag", `"`+strconv.FormatInt(stat.ModTime().UnixNano(), 36)+"-"+strconv.FormatInt(stat.Size(), 36)+`"`)
+
+		var content io.ReadSeeker = file
+		if DefaultThrottle != nil {
+			content = throttledReadSeeker{DefaultThrottle.ReaderContext(r.Context(), DefaultThrottle.clientKey(r), file), file}
+		}
+		http.ServeContent(w, r, fi.Name, stat.ModTime(), content)
+	})
+}
+
+// the headers for serving a file safely
+func downloadHeaders(fi *FileInfo) map[string]string {
+	mimetype, _, err := mime.ParseMediaType(fi.MimeType) // "text/plain; charset=utf-8"
+	if err != nil {
+		mimetype = "application/octet-stream"
+	}
+	disposition := "attachment"
+	if inSlice(inlineMimeTypes, mimetype) || (mimetype == "image/svg+xml" && fi.Sanitized) {
+		disposition = "inline"
+	} else {
+		mimetype = "application/octet-stream" // a browser has no reason to run it
+	}
+	if mimetype == "text/plain" {
+		mimetype += "; charset=utf-8"
+	}
+
+	name := sanitizeDownloadName(fi.OriginalName)
+	if len(name) == 0 {
+		name = fi.Name
+	}
+
+	return map[string]string{
+		"Content-Type":            mimetype,
+		"Content-Disposition":     mime.FormatMediaType(disposition, map[string]string{"filename": name}),
+		"X-Content-Type-Options":  "nosniff",
+		"Content-Security-Policy": "default-src 'none'; img-src 'self' data:; media-src 'self'; style-src 'unsafe-inline'; sandbox",
+	}
+}
+
+/*
+	The file name sent to a browser: the last part of the path, without control characters, quotes and leading dots
+	Returns an empty string when nothing is left
+*/
+func sanitizeDownloadName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 { // some browsers send the whole path of the file
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' || r == unicode.ReplacementChar || unicode.Is(unicode.Bidi_Control, r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimLeft(strings.TrimSpace(name), ".")
+
+	if len(name) > 255 { // keep the extension
+		ext := filepath.Ext(name)
+		if len(ext) > 16 {
+			ext = ""
+		}
+		base := name[:255-len(ext)]
+		for !utf8.ValidString(base) { // cut in the middle of a character
+			base = base[:len(base)-1]
+		}
+		name = base + ext
+	}
+
+	return name
+}
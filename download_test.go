@@ -0,0 +1,121 @@
+package fileupload
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_sanitizeDownloadName(t *testing.T) {
+	var list = []struct {
+		name     string
+		expected string
+	}{
+		{"photo.jpg", "photo.jpg"},
+		{`C:\Users\me\photo.jpg`, "photo.jpg"},
+		{"../../etc/passwd", "passwd"},
+		{"a\"b\r\nc.txt", "abc.txt"},
+		{"..hidden", "hidden"},
+		{"evil\u202egpj.exe", "evilgpj.exe"},
+		{"   ", ""},
+		{strings.Repeat("é", 200) + ".pdf", strings.Repeat("é", 125) + ".pdf"},
+	}
+	for _, l := range list {
+		if name := sanitizeDownloadName(l.name); name != l.expected {
+			t.Errorf("sanitizeDownloadName(%q): Returned[%q]. Expected[%q]", l.name, name, l.expected)
+		}
+	}
+}
+
+func Test_downloadHeaders(t *testing.T) {
+	var list = []struct {
+		fi          FileInfo
+		contentType string
+		disposition string
+	}{
+		{FileInfo{Name: "a.jpg", OriginalName: "cat.jpg", MimeType: "image/jpeg"}, "image/jpeg", `inline; filename=cat.jpg`},
+		{FileInfo{Name: "b.html", OriginalName: "page.html", MimeType: "text/html; charset=utf-8"}, "application/octet-stream", `attachment; filename=page.html`},
+		{FileInfo{Name: "c.txt", MimeType: "text/plain; charset=utf-8"}, "text/plain; charset=utf-8", `inline; filename=c.txt`},
+		{FileInfo{Name: "d.svg", MimeType: "image/svg+xml"}, "application/octet-stream", `attachment; filename=d.svg`},
+		{FileInfo{Name: "e.svg", MimeType: "image/svg+xml", Sanitized: true}, "image/svg+xml", `inline; filename=e.svg`},
+		{FileInfo{Name: "f", OriginalName: "my file.pdf", MimeType: "application/pdf"}, "application/octet-stream", `attachment; filename="my file.pdf"`},
+	}
+	for _, l := range list {
+		headers := downloadHeaders(&l.fi)
+		if headers["Content-Type"] != l.contentType || headers["Content-Disposition"] != l.disposition || headers["X-Content-Type-Options"] != "nosniff" {
+			t.Errorf("downloadHeaders(%s): Returned[%v]", l.fi.Name, headers)
+		}
+	}
+}
+
+func TestDownloadHandler(t *testing.T) {
+	tempDir := "testing-filevalidator"
+	dir, err := ioutil.TempDir("", tempDir) // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	req := setupRequestMultipartForm(&testFile{carTARGZ, "fileupload", "car.tar.gz"})
+	fi, err := UploadFileWithOwner(req.MultipartForm.File["fileupload"][0], dir, "alice", true) // an owner keeps the original name in a record
+	if err != nil {
+		t.Fatalf("UploadFileWithOwner(): Returned an error! [%s]", err)
+	}
+	if err := ioutil.WriteFile(dir+string(os.PathSeparator)+"page.html", []byte("<html><script>alert(1)</script></html>"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %s", err)
+	}
+
+	handler := DownloadHandler(DirectoryLookup(dir))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/download?file="+fi.Name, nil))
+	if w.Code != http.StatusOK || int64(w.Body.Len()) != fi.Size {
+		t.Fatalf("DownloadHandler(): Returned[%d %d bytes]", w.Code, w.Body.Len())
+	}
+	if disposition := w.Header().Get("Content-Disposition"); disposition != "attachment; filename=car.tar.gz" {
+		t.Errorf("DownloadHandler(): Wrong Content-Disposition [%s]", disposition)
+	}
+	etag := w.Header().Get("ETag")
+
+	// conditional and range requests
+	r := httptest.NewRequest("GET", "/download?file="+fi.Name, nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("DownloadHandler(If-None-Match): Returned[%d]. Expected 304", w.Code)
+	}
+	r = httptest.NewRequest("GET", "/download?file="+fi.Name, nil)
+	r.Header.Set("Range", "bytes=0-9")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusPartialContent || w.Body.Len() != 10 {
+		t.Errorf("DownloadHandler(Range): Returned[%d %d bytes]. Expected 206 with 10 bytes", w.Code, w.Body.Len())
+	}
+
+	// html is never shown
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/download?file=page.html", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/octet-stream" || !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment") {
+		t.Errorf("DownloadHandler(page.html): Returned[%d %v]", w.Code, w.Header())
+	}
+
+	var list = []struct {
+		target string
+		status int
+	}{
+		{"/download?file=..%2F..%2Fetc%2Fpasswd", http.StatusBadRequest},
+		{"/download?file=missing.txt", http.StatusNotFound},
+		{"/download?file=.state", http.StatusBadRequest},
+	}
+	for _, l := range list {
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", l.target, nil))
+		if w.Code != l.status {
+			t.Errorf("DownloadHandler(%s): Returned[%d]. Expected[%d]", l.target, w.Code, l.status)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package fileupload
+
+import (
+	"mime/multipart"
+	"sync"
+
+	"github.com/pkg/errors" // external dependency
+)
+
+var ErrNotAHook = errors.New("The hook does not implement any of the hook interfaces!")
+
+// an upload that hooks are called for
+type UploadEvent struct {
+	Header    *multipart.FileHeader
+	MimeType  string
+	Directory string // where the file is saved, changes made by hooks are ignored
+	Name      string // the name the file will be saved under, BeforeUpload hooks can change it
+	Owner     string
+}
+
+// called once the type and the directory of an upload are known, before it is saved. An error refuses the upload and is returned as is
+type BeforeUploadHook interface {
+	BeforeUpload(event *UploadEvent) error
+}
+
+// called once a file is saved and its FileInfo is complete
+type AfterStoreHook interface {
+	AfterStore(event *UploadEvent, fi *FileInfo)
+}
+
+// called once the thumbnail of an image is saved, before AfterStore
+type AfterThumbnailHook interface {
+	AfterThumbnail(event *UploadEvent, fi *FileInfo)
+}
+
+// called when an upload fails or is refused, also for infected files
+type ErrorHook interface {
+	OnError(event *UploadEvent, err error)
+}
+
+// called once a file and the files made from it are deleted
+type AfterDeleteHook interface {
+	AfterDelete(directory, name string)
+}
+
+/*
+	Hooks called by the upload and delete functions, a hook implements one or more of the hook interfaces
+	Hooks are called in the order they were added, on the goroutine of the upload. Slow work should be handed off
+	Example:
+	fileupload.DefaultHooks.Add(&auditLog{})
+*/
+type Hooks struct {
+	mu    sync.RWMutex
+	hooks []interface{}
+}
+
+// used by all uploads and deletes
+var DefaultHooks = &Hooks{}
+
+// returns ErrNotAHook for a value that implements none of the hook interfaces
+func (h *Hooks) Add(hook interface{}) error {
+	switch hook.(type) {
+	case BeforeUploadHook, AfterStoreHook, AfterThumbnailHook, ErrorHook, AfterDeleteHook:
+	default:
+		return ErrNotAHook
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks = append(h.hooks, hook)
+	return nil
+}
+
+// remove all hooks
+func (h *Hooks) Clear() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks = nil
+}
+
+// a copy of the list, hooks can add hooks
+func (h *Hooks) list() []interface{} {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append([]interface{}(nil), h.hooks...)
+}
+
+// stops at the first error
+func (h *Hooks) beforeUpload(event *UploadEvent) error {
+	for _, hook := range h.list() {
+		if hook, ok := hook.(BeforeUploadHook); ok {
+			if err := hook.BeforeUpload(event); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (h *Hooks) afterStore(event *UploadEvent, fi *FileInfo) {
+	for _, hook := range h.list() {
+		if hook, ok := hook.(AfterStoreHook); ok {
+			hook.AfterStore(event, fi)
+		}
+	}
+}
+
+func (h *Hooks) afterThumbnail(event *UploadEvent, fi *FileInfo) {
+	for _, hook := range h.list() {
+		if hook, ok := hook.(AfterThumbnailHook); ok {
+			hook.AfterThumbnail(event, fi)
+		}
+	}
+}
+
+func (h *Hooks) onError(event *UploadEvent, err error) {
+	for _, hook := range h.list() {
+		if hook, ok := hook.(ErrorHook); ok {
+			hook.OnError(event, err)
+		}
+	}
+}
+
+func (h *Hooks) afterDelete(directory, name string) {
+	for _, hook := range h.list() {
+		if hook, ok := hook.(AfterDeleteHook); ok {
+			hook.AfterDelete(directory, name)
+		}
+	}
+}
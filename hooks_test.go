@@ -0,0 +1,123 @@
+package fileupload
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors" // external dependency
+)
+
+var errNoArchives = errors.New("no archives")
+
+// records the calls of every hook
+type testHook struct {
+	calls []string
+}
+
+func (h *testHook) BeforeUpload(event *UploadEvent) error {
+	h.calls = append(h.calls, "before "+event.Header.Filename)
+	if event.Header.Filename == "refused.tar.gz" {
+		return errNoArchives
+	}
+	event.Name = "renamed-" + event.Header.Filename
+	event.Directory = os.TempDir() // ignored, the file is saved in the directory of the upload
+	return nil
+}
+func (h *testHook) AfterStore(event *UploadEvent, fi *FileInfo) {
+	h.calls = append(h.calls, "store "+fi.Name)
+}
+func (h *testHook) AfterThumbnail(event *UploadEvent, fi *FileInfo) {
+	h.calls = append(h.calls, "thumbnail "+fi.ThumbnailName)
+}
+func (h *testHook) OnError(event *UploadEvent, err error) {
+	h.calls = append(h.calls, "error "+err.Error())
+}
+func (h *testHook) AfterDelete(directory, name string) {
+	h.calls = append(h.calls, "delete "+name)
+}
+
+func TestHooks(t *testing.T) {
+	tempDir := "testing-filevalidator"
+	dir, err := ioutil.TempDir("", tempDir) // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	if err := DefaultHooks.Add(42); err != ErrNotAHook {
+		t.Errorf("Hooks.Add(): Should return ErrNotAHook! [%v]", err)
+	}
+	hook := &testHook{}
+	if err := DefaultHooks.Add(hook); err != nil {
+		t.Fatalf("Hooks.Add(): Returned an error! [%s]", err)
+	}
+	defer DefaultHooks.Clear()
+
+	req := setupRequestMultipartForm(&testFile{carTARGZ, "fileupload", "car.tar.gz"}, &testFile{carTARGZ, "refused", "refused.tar.gz"})
+	fi, err := UploadFile(req.MultipartForm.File["fileupload"][0], dir, true)
+	if err != nil {
+		t.Fatalf("UploadFile(): Returned an error! [%s]", err)
+	}
+	if fi.Name != "renamed-car.tar.gz" {
+		t.Errorf("UploadFile(): Not renamed by the hook [%s]", fi.Name)
+	}
+	if _, err := UploadFile(req.MultipartForm.File["refused"][0], dir, true); err != errNoArchives {
+		t.Errorf("UploadFile(): Should return the error of the hook! [%v]", err)
+	}
+	if _, err := UploadFile(req.MultipartForm.File["fileupload"][0], dir, true); err != ErrFileExists {
+		t.Errorf("UploadFile(): A hook should not replace a file! [%v]", err)
+	}
+	if err := DeleteFile(dir, fi.Name); err != nil {
+		t.Errorf("DeleteFile(): Returned an error! [%s]", err)
+	}
+
+	thumbnailDir, err := ioutil.TempDir("", "testing-filevalidator-thumbnails")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(thumbnailDir)
+	req = setupRequestMultipartForm(&testFile{gopherPNG, "imageupload", "gopher.png"})
+	fi, err = UploadImageWithThumbnail(req.MultipartForm.File["imageupload"][0], dir, thumbnailDir)
+	if err != nil {
+		t.Fatalf("UploadImageWithThumbnail(): Returned an error! [%s]", err)
+	}
+	if fi.Name != "renamed-gopher.jpg" || fi.ThumbnailName != "renamed-gopher.jpg" {
+		t.Errorf("UploadImageWithThumbnail(): Not renamed by the hook [%s %s]", fi.Name, fi.ThumbnailName)
+	}
+	if err := DeleteImage(dir, thumbnailDir, fi.Name); err != nil {
+		t.Errorf("DeleteImage(): Returned an error! [%s]", err)
+	}
+
+	// the name that is checked is the one of the saved format, a kept animation is saved as .gif
+	existing := dir + string(os.PathSeparator) + "renamed-anim.gif"
+	if err := ioutil.WriteFile(existing, []byte("another upload"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %s", err)
+	}
+	req = setupRequestMultipartForm(&testFile{animatedGIFBase64(3), "imageupload", "anim.gif"})
+	if _, err := UploadImageWithOptions(req.MultipartForm.File["imageupload"][0], dir, thumbnailDir, ImageOptions{Animation: AnimationKeep}); err != ErrFileExists {
+		t.Errorf("UploadImageWithOptions(anim.gif): Should return ErrFileExists! [%v]", err)
+	}
+	if b, err := ioutil.ReadFile(existing); err != nil || string(b) != "another upload" {
+		t.Errorf("UploadImageWithOptions(anim.gif): The file of another upload was changed! [%s %v]", b, err)
+	}
+
+	expected := []string{
+		"before car.tar.gz", "store renamed-car.tar.gz",
+		"before refused.tar.gz", "error no archives",
+		"before car.tar.gz", "error " + ErrFileExists.Error(),
+		"delete renamed-car.tar.gz",
+		"before gopher.png", "thumbnail renamed-gopher.jpg", "store renamed-gopher.jpg",
+		"delete renamed-gopher.jpg",
+		"before anim.gif", "error " + ErrFileExists.Error(),
+	}
+	if len(hook.calls) != len(expected) {
+		t.Fatalf("Hooks: Called[%q]. Expected[%q]", hook.calls, expected)
+	}
+	for i := range expected {
+		if hook.calls[i] != expected[i] {
+			t.Errorf("Hooks: Called[%q]. Expected[%q]", hook.calls, expected)
+			break
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package fileupload
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/pkg/errors" // external dependency
+)
+
+// how animated images (GIF, APNG, WebP) are saved, see ImageOptions.Animation
+type AnimationFormat int
+
+const (
+	AnimationFlatten AnimationFormat = iota // save the first frame as a jpeg, like any other image. The default
+	AnimationKeep                           // save the uploaded animation unchanged, refused when it is larger than MaxWidth/MaxHeight
+	AnimationWebP                           // convert to an animated WebP, requires ffmpeg
+	AnimationMP4                            // convert to a H.264 video without sound, requires ffmpeg
+)
+
+// the ffmpeg executable used to convert animations, a name in $PATH or a full path
+var FFmpegPath = "ffmpeg"
+
+var ErrAnimationConversion = errors.New("The animation could not be converted! Is ffmpeg installed?")
+
+/*
+	Save an animated image, either unchanged or converted with ffmpeg
+	Converted animations are scaled down to MaxWidth and MaxHeight, kept animations larger than that return an *ImageLimitError
+	Animated WebP files are always kept, ffmpeg can not decode them
+	Returns data about the saved file, the name gets the extension of the saved format
+*/
+func saveAnimation(buffer []byte, h *imageHeader, oldName, uuidBase, directory string, options ImageOptions) (*FileInfo, error) {
+	fi := &FileInfo{OriginalName: oldName, IsImage: true, Directory: directory, Width: h.Width, Height: h.Height, Frames: h.Frames, Duration: h.Duration}
+
+	if h.Format == "webp" {
+		options.Animation = AnimationKeep
+	}
+
+	if options.Animation == AnimationKeep { // can not be scaled down without converting it
+		if options.MaxWidth > 0 && h.Width > options.MaxWidth {
+			return nil, &ImageLimitError{"width", float64(h.Width), float64(options.MaxWidth)}
+		}
+		if options.MaxHeight > 0 && h.Height > options.MaxHeight {
+			return nil, &ImageLimitError{"height", float64(h.Height), float64(options.MaxHeight)}
+		}
+	}
+
+	fi.Name = uuidBase + "." + animationExtension(h, options.Animation)
+	switch options.Animation {
+	case AnimationWebP:
+		fi.MimeType = "image/webp"
+	case AnimationMP4:
+		fi.MimeType, fi.IsImage = "video/mp4", false
+	default:
+		fi.MimeType = "image/" + h.Format
+	}
+	root, err := OpenRoot(directory)
+	if err != nil {
+		return nil, errors.Wrap(err, "saveAnimation()")
+	}
+	defer root.Close()
+
+	if options.Animation == AnimationWebP || options.Animation == AnimationMP4 {
+		fi.Width, fi.Height = fitBoundingBox(h.Width, h.Height, options.MaxWidth, options.MaxHeight)
+		if options.Animation == AnimationMP4 { // H.264 needs even dimensions
+			fi.Width, fi.Height = fi.Width&^1, fi.Height&^1
+		}
+		if buffer, err = convertAnimation(buffer, h.Format, options.Animation, fi.Width, fi.Height); err != nil {
+			return nil, errors.Wrap(err, "saveAnimation()")
+		}
+	}
+	if err := root.writeNewFile(fi.Name, buffer, 0644); err == ErrFileExists {
+		return nil, err
+	} else if err != nil {
+		return nil, errors.Wrap(err, "saveAnimation()")
+	}
+	fi.Size = int64(len(buffer))
+
+	return fi, nil
+}
+
+// the extension of a saved animation, the format of the upload when it is kept. Animated WebP files are always kept
+func animationExtension(h *imageHeader, format AnimationFormat) string {
+	if h.Format != "webp" {
+		switch format {
+		case AnimationWebP:
+			return "webp"
+		case AnimationMP4:
+			return "mp4"
+		}
+	}
+	return h.Format
+}
+
+/*
+	Run ffmpeg, the animation is piped to stdin and the converted animation is returned
+	ffmpeg writes to a file in a private temporary directory (mp4 and webp need a file to seek in), never to the upload directory
+*/
+func convertAnimation(buffer []byte, inputFormat string, format AnimationFormat, width, height int) ([]byte, error) {
+	tempDir, err := ioutil.TempDir("", "fileupload-ffmpeg-")
+	if err != nil {
+		return nil, errors.Wrap(err, "convertAnimation()")
+	}
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, "animation")
+
+	args := []string{"-hide_banner", "-loglevel", "error", "-y", "-f", inputFormat, "-i", "pipe:0"}
+	if inputFormat == "png" {
+		args[5] = "apng"
+	}
+
+	scale := "scale=" + strconv.Itoa(width) + ":" + strconv.Itoa(height)
+	switch format {
+	case AnimationWebP:
+		args = append(args, "-vf", scale, "-c:v", "libwebp_anim", "-lossless", "0", "-quality", "80", "-loop", "0", "-f", "webp", path)
+	case AnimationMP4:
+		args = append(args, "-vf", scale, "-c:v", "libx264", "-pix_fmt", "yuv420p", "-movflags", "+faststart", "-an", "-f", "mp4", path)
+	default:
+		return nil, errors.Errorf("convertAnimation(): Unknown format [%d]", format)
+	}
+
+	cmd := exec.Command(FFmpegPath, args...)
+	cmd.Stdin = bytes.NewReader(buffer)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(ErrAnimationConversion, "convertAnimation() [%s] %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	converted, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "convertAnimation()")
+	}
+	return converted, nil
+}
@@ -0,0 +1,97 @@
+package fileupload
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func Test_readWebPAnimation(t *testing.T) {
+	// RIFF header, an ANIM chunk and three ANMF chunks of 40ms each, the frame bitstreams are left out
+	buf := &bytes.Buffer{}
+	buf.WriteString("RIFF\x00\x00\x00\x00WEBP")
+	buf.WriteString("ANIM")
+	binary.Write(buf, binary.LittleEndian, uint32(6))
+	buf.Write(make([]byte, 6))
+	for i := 0; i < 3; i++ {
+		buf.WriteString("ANMF")
+		binary.Write(buf, binary.LittleEndian, uint32(16))
+		frame := make([]byte, 16)
+		frame[12] = 40
+		buf.Write(frame)
+	}
+
+	frames, duration, err := readWebPAnimation(bufio.NewReader(buf), 0)
+	if err != nil {
+		t.Fatalf("readWebPAnimation(): Returned an error! [%s]", err)
+	}
+	if frames != 3 || duration != 120 {
+		t.Errorf("readWebPAnimation(): Returned[%d frames %dms]. Expected: 3 frames 120ms", frames, duration)
+	}
+}
+
+func Test_UploadImageWithOptions_animation(t *testing.T) {
+	tempDir1, tempDir2 := "testing-filevalidator-images", "testing-filevalidator-thumbnails"
+	dir1, err := ioutil.TempDir("", tempDir1) // make two temporary directories
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir2, err := ioutil.TempDir("", tempDir2)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir1) // delete the temp directories
+	defer os.RemoveAll(dir2)
+
+	var list = []struct {
+		animation AnimationFormat
+		extension string
+		frames    int
+	}{
+		{AnimationKeep, ".gif", 5},
+		{AnimationFlatten, ".jpg", 5}, // the frames of the upload
+		{AnimationWebP, ".webp", 5},
+		{AnimationMP4, ".mp4", 5},
+	}
+	if (ImageOptions{}).Animation != AnimationFlatten { // animations are saved like before unless asked otherwise
+		t.Errorf("ImageOptions: The default animation format is [%d]. Expected AnimationFlatten", (ImageOptions{}).Animation)
+	}
+
+	for _, l := range list {
+		if l.animation == AnimationWebP || l.animation == AnimationMP4 {
+			if _, err := exec.LookPath(FFmpegPath); err != nil {
+				t.Logf("UploadImageWithOptions(Animation: %d): ffmpeg is not installed, skipping", l.animation)
+				continue
+			}
+		}
+
+		req := setupRequestMultipartForm(&testFile{animatedGIFBase64(5), "imageupload", "anim.gif"})
+		fi, err := UploadImageWithOptions(req.MultipartForm.File["imageupload"][0], dir1, dir2, ImageOptions{Animation: l.animation})
+		if err != nil {
+			t.Errorf("UploadImageWithOptions(Animation: %d): Returned an error! [%s]", l.animation, err)
+			continue
+		}
+
+		if !strings.HasSuffix(fi.Name, l.extension) || fi.Frames != l.frames || fi.Duration != 500 {
+			t.Errorf("UploadImageWithOptions(Animation: %d): Returned[%s %d frames %dms]. Expected: %s %d frames 500ms", l.animation, fi.Name, fi.Frames, fi.Duration, l.extension, l.frames)
+		}
+		if _, err := os.Stat(dir1 + string(os.PathSeparator) + fi.Name); err != nil { // check if the image exists
+			t.Errorf("UploadImageWithOptions(Animation: %d): Image does not exist! [%s]", l.animation, err)
+		}
+		if _, err := os.Stat(dir2 + string(os.PathSeparator) + fi.ThumbnailName); err != nil || !strings.HasSuffix(fi.ThumbnailName, ".jpg") { // the poster frame
+			t.Errorf("UploadImageWithOptions(Animation: %d): Thumbnail does not exist! [%s]", l.animation, err)
+		}
+	}
+
+	// a kept animation can not be scaled down
+	req := setupRequestMultipartForm(&testFile{animatedGIFBase64(5), "imageupload", "anim.gif"})
+	_, err = UploadImageWithOptions(req.MultipartForm.File["imageupload"][0], dir1, dir2, ImageOptions{Animation: AnimationKeep, MaxWidth: 4})
+	if limit, ok := err.(*ImageLimitError); !ok || limit.Limit != "width" {
+		t.Errorf("UploadImageWithOptions(Animation: AnimationKeep): Returned[%v]. Expected an *ImageLimitError for the width", err)
+	}
+}
@@ -0,0 +1,86 @@
+package fileupload
+
+import (
+	"math"
+)
+
+// how a thumbnail is cut to the size set in ImageOptions.ThumbnailWidth and ImageOptions.ThumbnailHeight
+type CropMode int
+
+const (
+	CropNone       CropMode = iota // scale the whole image to fit inside the thumbnail size
+	CropCenter                     // fill the thumbnail size, cut equally from both sides
+	CropSmart                      // fill the thumbnail size, keep the most interesting area (libvips attention strategy)
+	CropFocalPoint                 // fill the thumbnail size, keep ImageOptions.FocalPoint as close to the center as possible
+)
+
+// a point in an image, relative to its size: {0, 0} is the top left corner and {0.5, 0.5} is the center
+type FocalPoint struct {
+	X float64
+	Y float64
+}
+
+// the final size of a thumbnail, the old behaviour (75 pixels high, same aspect ratio) is kept when no size is set
+func thumbnailSize(width, height int, options ImageOptions) (int, int) {
+	thumbWidth, thumbHeight := options.ThumbnailWidth, options.ThumbnailHeight
+
+	if thumbWidth <= 0 && thumbHeight <= 0 {
+		widthRatio := float64(width) / float64(height)
+		thumbHeight = 75
+		thumbWidth = int(math.Floor((float64(thumbHeight) * widthRatio) + 0.5)) // round the float
+		return thumbWidth, thumbHeight
+	}
+
+	if options.Crop == CropNone { // keep the aspect ratio, a missing dimension is not a limit
+		scale := math.Inf(1)
+		if thumbWidth > 0 {
+			scale = float64(thumbWidth) / float64(width)
+		}
+		if thumbHeight > 0 {
+			scale = math.Min(scale, float64(thumbHeight)/float64(height))
+		}
+		return int(math.Max(1, math.Floor((float64(width)*scale)+0.5))), int(math.Max(1, math.Floor((float64(height)*scale)+0.5)))
+	}
+
+	// cropped thumbnails are square when only one dimension is set
+	if thumbWidth <= 0 {
+		thumbWidth = thumbHeight
+	}
+	if thumbHeight <= 0 {
+		thumbHeight = thumbWidth
+	}
+	return thumbWidth, thumbHeight
+}
+
+/*
+	The area of the original image to keep for a focal point crop
+	The area has the aspect ratio of the thumbnail, is as large as possible and is centered on the focal point without leaving the image
+*/
+func focalCropArea(width, height, thumbWidth, thumbHeight int, focal FocalPoint) (left, top, areaWidth, areaHeight int) {
+	ratio := float64(thumbWidth) / float64(thumbHeight)
+
+	areaWidth, areaHeight = width, int(math.Floor((float64(width)/ratio)+0.5))
+	if areaHeight > height { // the image is wider than the thumbnail
+		areaWidth, areaHeight = int(math.Floor((float64(height)*ratio)+0.5)), height
+	}
+	if areaWidth > width {
+		areaWidth = width
+	}
+
+	focal.X = math.Max(0, math.Min(1, focal.X))
+	focal.Y = math.Max(0, math.Min(1, focal.Y))
+
+	left = clampInt(int(math.Floor((focal.X*float64(width))-(float64(areaWidth)/2)+0.5)), 0, width-areaWidth)
+	top = clampInt(int(math.Floor((focal.Y*float64(height))-(float64(areaHeight)/2)+0.5)), 0, height-areaHeight)
+	return left, top, areaWidth, areaHeight
+}
+
+func clampInt(val, min, max int) int {
+	if val < min {
+		return min
+	}
+	if val > max {
+		return max
+	}
+	return val
+}
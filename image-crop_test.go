@@ -0,0 +1,85 @@
+package fileupload
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"gopkg.in/h2non/bimg.v1" // external dependency
+)
+
+func Test_thumbnailSize(t *testing.T) {
+	var list = []struct {
+		width, height       int
+		options             ImageOptions
+		expWidth, expHeight int
+	}{
+		{250, 340, ImageOptions{}, 55, 75}, // old behaviour, 75 pixels high
+		{250, 340, ImageOptions{ThumbnailWidth: 100, ThumbnailHeight: 100}, 74, 100},
+		{340, 250, ImageOptions{ThumbnailWidth: 100}, 100, 74},
+		{250, 340, ImageOptions{ThumbnailWidth: 100, ThumbnailHeight: 100, Crop: CropCenter}, 100, 100},
+		{250, 340, ImageOptions{ThumbnailWidth: 120, Crop: CropSmart}, 120, 120}, // square when only one dimension is set
+		{250, 340, ImageOptions{ThumbnailHeight: 90, ThumbnailWidth: 160, Crop: CropFocalPoint}, 160, 90},
+	}
+	for _, l := range list {
+		if w, h := thumbnailSize(l.width, l.height, l.options); w != l.expWidth || h != l.expHeight {
+			t.Errorf("thumbnailSize(%d, %d, %+v): Returned[%dx%d]. Expected: %dx%d", l.width, l.height, l.options, w, h, l.expWidth, l.expHeight)
+		}
+	}
+}
+
+func Test_focalCropArea(t *testing.T) {
+	var list = []struct {
+		width, height, thumbWidth, thumbHeight int
+		focal                                  FocalPoint
+		left, top, areaWidth, areaHeight       int
+	}{
+		{1000, 500, 100, 100, FocalPoint{0.5, 0.5}, 250, 0, 500, 500},
+		{1000, 500, 100, 100, FocalPoint{0, 0}, 0, 0, 500, 500},       // stays inside the image
+		{1000, 500, 100, 100, FocalPoint{0.9, 0.5}, 500, 0, 500, 500}, // stays inside the image
+		{500, 1000, 100, 100, FocalPoint{0.5, 0.2}, 0, 0, 500, 500},
+		{500, 1000, 100, 100, FocalPoint{0.5, 0.6}, 0, 350, 500, 500},
+		{1000, 1000, 160, 90, FocalPoint{0.5, 0.5}, 0, 219, 1000, 563},
+		{1000, 1000, 160, 90, FocalPoint{-3, 7}, 0, 437, 1000, 563}, // out of range focal points are clamped
+	}
+	for _, l := range list {
+		left, top, areaWidth, areaHeight := focalCropArea(l.width, l.height, l.thumbWidth, l.thumbHeight, l.focal)
+		if left != l.left || top != l.top || areaWidth != l.areaWidth || areaHeight != l.areaHeight {
+			t.Errorf("focalCropArea(%d, %d, %d, %d, %v): Returned[%d %d %d %d]. Expected: %d %d %d %d", l.width, l.height, l.thumbWidth, l.thumbHeight, l.focal, left, top, areaWidth, areaHeight, l.left, l.top, l.areaWidth, l.areaHeight)
+		}
+	}
+}
+
+func Test_UploadImageWithOptions_crop(t *testing.T) {
+	tempDir1, tempDir2 := "testing-filevalidator-images", "testing-filevalidator-thumbnails"
+	dir1, err := ioutil.TempDir("", tempDir1) // make two temporary directories
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir2, err := ioutil.TempDir("", tempDir2)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir1) // delete the temp directories
+	defer os.RemoveAll(dir2)
+
+	for _, crop := range []CropMode{CropCenter, CropSmart, CropFocalPoint} {
+		req := setupRequestMultipartForm(&testFile{gopherPNG, "imageupload", "gopher.png"})
+		options := ImageOptions{ThumbnailWidth: 64, ThumbnailHeight: 64, Crop: crop, FocalPoint: FocalPoint{0.5, 0.25}}
+
+		fi, err := UploadImageWithOptions(req.MultipartForm.File["imageupload"][0], dir1, dir2, options)
+		if err != nil {
+			t.Errorf("UploadImageWithOptions(Crop: %d): Returned an error! [%s]", crop, err)
+			continue
+		}
+
+		buf, err := bimg.Read(dir2 + string(os.PathSeparator) + fi.Name)
+		if err != nil { // check if the thumbnail exists
+			t.Errorf("UploadImageWithOptions(Crop: %d): Thumbnail does not exist! [%s]", crop, err)
+			continue
+		}
+		if size, err := bimg.NewImage(buf).Size(); err != nil || size.Width != 64 || size.Height != 64 {
+			t.Errorf("UploadImageWithOptions(Crop: %d): Thumbnail is not square! [%dx%d]", crop, size.Width, size.Height)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package fileupload
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors" // external dependencies
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+)
+
+// ISO base media file "ftyp" brands of HEIF images
+var heicBrands = []string{"heic", "heix", "heim", "heis", "hevc", "hevx"}
+var heifBrands = []string{"mif1", "msf1"}
+
+/*
+	Find image types that http.DetectContentType() does not know, returns an empty string for everything else
+	buffer - the first bytes of a file. SVG images are found with isSVG()
+*/
+func sniffImageType(buffer []byte) string {
+	switch {
+	case bytes.HasPrefix(buffer, []byte("II*\x00")), bytes.HasPrefix(buffer, []byte("MM\x00*")):
+		return "image/tiff"
+	case len(buffer) >= 12 && string(buffer[4:8]) == "ftyp":
+		size := int(binary.BigEndian.Uint32(buffer[:4]))
+		if size > len(buffer) {
+			size = len(buffer)
+		}
+		mimetype := ""
+		for i := 8; i+4 <= size; i += 4 { // major brand, minor version (never matches) and compatible brands
+			brand := string(buffer[i : i+4])
+			if inSlice(heicBrands, brand) {
+				return "image/heic"
+			}
+			if inSlice(heifBrands, brand) {
+				mimetype = "image/heif"
+			}
+		}
+		return mimetype
+	}
+
+	return ""
+}
+
+// bytes read by isSVG() to find the root element, getMimeType() runs it for every upload and listed file
+const svgSniffLimit = 64 << 10
+
+/*
+	The root element of the document is <svg> in the SVG namespace or without a namespace, "<svg:svg xmlns:svg=...>" included
+	The document is read with an XML token scan up to the root element, comments, an xml declaration and a doctype
+	with an internal subset may come before it. Used by getMimeType(), SVG is never found by a byte prefix
+	A root element that does not start within svgSniffLimit bytes is not an SVG image
+*/
+func isSVG(r io.Reader) bool {
+	decoder := xml.NewDecoder(io.LimitReader(r, svgSniffLimit))
+	decoder.Strict = false // entities declared in the doctype are not known, they must not hide the root element
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return false
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			return t.Name.Local == "svg" && (t.Name.Space == svgNamespace || t.Name.Space == "")
+		case xml.CharData:
+			if len(bytes.TrimSpace(bytes.TrimPrefix(t, []byte("\xef\xbb\xbf")))) > 0 { // text before the root element, not an XML document
+				return false
+			}
+		}
+	}
+}
+
+// isSVG() for a file, read from the start. The position is reset to the start of the file
+func sniffSVG(file io.ReadSeeker) (bool, error) {
+	if _, err := file.Seek(0, 0); err != nil {
+		return false, err
+	}
+	svg := isSVG(file)
+	if _, err := file.Seek(0, 0); err != nil {
+		return false, err
+	}
+	return svg, nil
+}
+
+/*
+	Read the size of a HEIF/HEIC image from the "ispe" (image spatial extents) properties, without decoding
+	The largest one is used, a HEIF file also has entries for its thumbnails and tiles
+*/
+func readHEIFHeader(r io.Reader) (width, height int, err error) {
+	var meta []byte
+	for meta == nil { // top level boxes, look for "meta"
+		boxType, body, err := readBox(r)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "readHEIFHeader()")
+		}
+		if boxType == "meta" {
+			if meta, err = ioutil.ReadAll(io.LimitReader(body, 1<<20)); err != nil { // the metadata is small, an image does not need more than 1MB
+				return 0, 0, errors.Wrap(err, "readHEIFHeader()")
+			}
+		} else if _, err := io.Copy(ioutil.Discard, body); err != nil {
+			return 0, 0, errors.Wrap(err, "readHEIFHeader()")
+		}
+	}
+
+	if len(meta) < 4 {
+		return 0, 0, errors.New("readHEIFHeader(): Short meta box")
+	}
+	iprp := findBox(meta[4:], "iprp") // "meta" starts with a version and flags
+	ipco := findBox(iprp, "ipco")
+
+	for rest := ipco; len(rest) >= 8; {
+		size := int(binary.BigEndian.Uint32(rest[:4]))
+		if size < 8 || size > len(rest) {
+			break
+		}
+		if string(rest[4:8]) == "ispe" && size >= 20 { // version and flags, width, height
+			w, h := int(binary.BigEndian.Uint32(rest[12:16])), int(binary.BigEndian.Uint32(rest[16:20]))
+			if w*h > width*height {
+				width, height = w, h
+			}
+		}
+		rest = rest[size:]
+	}
+
+	if width == 0 || height == 0 {
+		return 0, 0, errors.New("readHEIFHeader(): No image size found")
+	}
+	return width, height, nil
+}
+
+// read the next box header, the body reader has to be consumed before the next call
+func readBox(r io.Reader) (string, io.Reader, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", nil, err
+	}
+	size := int64(binary.BigEndian.Uint32(header[:4]))
+	headerSize := int64(8)
+
+	switch size {
+	case 0: // the box goes to the end of the file
+		return string(header[4:]), r, nil
+	case 1: // 64 bit size
+		large := make([]byte, 8)
+		if _, err := io.ReadFull(r, large); err != nil {
+			return "", nil, err
+		}
+		size, headerSize = int64(binary.BigEndian.Uint64(large)), 16
+	}
+	if size < headerSize {
+		return "", nil, errors.Errorf("Bad box size [%d]", size)
+	}
+
+	return string(header[4:]), io.LimitReader(r, size-headerSize), nil
+}
+
+// the body of the first child box with the type, from an in memory container box
+func findBox(buffer []byte, boxType string) []byte {
+	for len(buffer) >= 8 {
+		size := int(binary.BigEndian.Uint32(buffer[:4]))
+		if size < 8 || size > len(buffer) {
+			return nil
+		}
+		if string(buffer[4:8]) == boxType {
+			return buffer[8:size]
+		}
+		buffer = buffer[size:]
+	}
+	return nil
+}
+
+// the size of the browser's default viewport, used when an SVG image does not declare one
+const svgDefaultWidth, svgDefaultHeight = 300, 150
+
+/*
+	Read the size of an SVG image from the width, height and viewBox attributes of the root element
+	Relative sizes ("100%", "2em") fall back to the viewBox
+*/
+func readSVGHeader(r io.Reader) (width, height int, err error) {
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "readSVGHeader()")
+		}
+
+		root, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if root.Name.Local != "svg" {
+			return 0, 0, errors.New("readSVGHeader(): The root element is not <svg>")
+		}
+
+		var w, h, viewBoxW, viewBoxH float64
+		for _, attr := range root.Attr {
+			switch attr.Name.Local {
+			case "width":
+				w = svgLength(attr.Value)
+			case "height":
+				h = svgLength(attr.Value)
+			case "viewBox":
+				if fields := strings.FieldsFunc(attr.Value, func(r rune) bool { return r == ' ' || r == ',' }); len(fields) == 4 {
+					viewBoxW, _ = strconv.ParseFloat(fields[2], 64)
+					viewBoxH, _ = strconv.ParseFloat(fields[3], 64)
+				}
+			}
+		}
+
+		switch {
+		case w > 0 && h > 0:
+		case w > 0 && viewBoxW > 0 && viewBoxH > 0: // keep the aspect ratio of the viewBox
+			h = w * viewBoxH / viewBoxW
+		case h > 0 && viewBoxW > 0 && viewBoxH > 0:
+			w = h * viewBoxW / viewBoxH
+		case viewBoxW > 0 && viewBoxH > 0:
+			w, h = viewBoxW, viewBoxH
+		default:
+			w, h = svgDefaultWidth, svgDefaultHeight
+		}
+		if math.IsNaN(w) || math.IsNaN(h) || w > math.MaxInt32 || h > math.MaxInt32 { // "1e300" does not fit an int
+			return 0, 0, errors.Wrapf(ErrInvalidImageSize, "readSVGHeader() [%g x %g]", w, h)
+		}
+
+		return int(math.Ceil(w)), int(math.Ceil(h)), nil
+	}
+}
+
+// an SVG length in pixels, 0 for relative or unknown units
+func svgLength(value string) float64 {
+	units := map[string]float64{"": 1, "px": 1, "pt": 96.0 / 72, "pc": 16, "in": 96, "cm": 96 / 2.54, "mm": 96 / 25.4}
+
+	value = strings.TrimSpace(value)
+	i := strings.IndexFunc(value, func(r rune) bool { return (r < '0' || r > '9') && r != '.' && r != '-' && r != '+' && r != 'e' })
+	if i == -1 {
+		i = len(value)
+	}
+
+	number, err := strconv.ParseFloat(value[:i], 64)
+	factor, ok := units[strings.ToLower(strings.TrimSpace(value[i:]))]
+	if err != nil || !ok || number <= 0 {
+		return 0
+	}
+	return number * factor
+}
+
+/*
+	Get an uploaded image ready for libvips
+	SVG images are sanitized before rasterizing, BMP images are converted to PNG since libvips can only read them with ImageMagick
+*/
+func prepareImageBuffer(buffer []byte, format string) ([]byte, error) {
+	switch format {
+	case "svg":
+		clean, _, err := sanitizeSVG(buffer)
+		if err != nil {
+			return nil, errors.Wrap(err, "prepareImageBuffer()")
+		}
+		return clean, nil
+	case "bmp":
+		img, _, err := image.Decode(bytes.NewReader(buffer)) // the size was checked by readImageHeader()
+		if err != nil {
+			return nil, errors.Wrap(err, "prepareImageBuffer()")
+		}
+		converted := &bytes.Buffer{}
+		if err := png.Encode(converted, img); err != nil {
+			return nil, errors.Wrap(err, "prepareImageBuffer()")
+		}
+		return converted.Bytes(), nil
+	}
+
+	return buffer, nil
+}
@@ -0,0 +1,188 @@
+package fileupload
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors" // external dependencies
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// build a box of an ISO base media file
+func isoBox(boxType string, body ...[]byte) []byte {
+	content := bytes.Join(body, nil)
+	box := make([]byte, 8, 8+len(content))
+	binary.BigEndian.PutUint32(box, uint32(8+len(content)))
+	copy(box[4:], boxType)
+	return append(box, content...)
+}
+
+func ispeBox(width, height uint32) []byte {
+	body := make([]byte, 12)
+	binary.BigEndian.PutUint32(body[4:], width)
+	binary.BigEndian.PutUint32(body[8:], height)
+	return isoBox("ispe", body)
+}
+
+// a HEIC file with only the boxes needed to read the size: a 4032x3024 image with a 320x240 thumbnail
+func testHEIC() []byte {
+	ftyp := isoBox("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	meta := isoBox("meta", []byte{0, 0, 0, 0}, isoBox("hdlr", make([]byte, 24)), isoBox("iprp", isoBox("ipco", ispeBox(320, 240), ispeBox(4032, 3024))))
+	return append(append(ftyp, meta...), isoBox("mdat", make([]byte, 64))...)
+}
+
+func Test_sniffImageType(t *testing.T) {
+	var list = []struct {
+		buffer   string
+		mimetype string
+	}{
+		{"II*\x00\x08\x00\x00\x00", "image/tiff"},
+		{"MM\x00*\x00\x00\x00\x08", "image/tiff"},
+		{string(testHEIC()), "image/heic"},
+		{"\x00\x00\x00\x14ftypmif1\x00\x00\x00\x00mif1", "image/heif"},
+		{"\x00\x00\x00\x14ftypisom\x00\x00\x00\x00mp41", ""},   // a video
+		{`<svg xmlns="http://www.w3.org/2000/svg"></svg>`, ""}, // found by isSVG()
+		{"BM\x00\x00", ""}, // found by http.DetectContentType()
+		{"plain text", ""},
+	}
+	for _, l := range list {
+		if mimetype := sniffImageType([]byte(l.buffer)); mimetype != l.mimetype {
+			t.Errorf("sniffImageType(%q): Returned[%s]. Expected: %s", l.buffer[:8], mimetype, l.mimetype)
+		}
+	}
+}
+
+func Test_isSVG(t *testing.T) {
+	var list = []struct {
+		document string
+		svg      bool
+	}{
+		{`<svg xmlns="http://www.w3.org/2000/svg"></svg>`, true},
+		{"\xef\xbb\xbf<?xml version=\"1.0\"?>\n<!-- logo -->\n<!DOCTYPE svg>\n<svg>", true},
+		{"<!-- " + strings.Repeat("x", 1000) + " -->" + `<svg xmlns="http://www.w3.org/2000/svg">`, true}, // after the first 512 bytes
+		{`<!DOCTYPE svg [ <!ENTITY x "<svg>"> ]><svg xmlns="http://www.w3.org/2000/svg" width="&x;">`, true},
+		{`<svg:svg xmlns:svg="http://www.w3.org/2000/svg"><svg:script>alert(1)</svg:script></svg:svg>`, true},
+		{`<?xml version="1.0"?><html><svg></svg></html>`, false},
+		{`<svg xmlns="http://example.com/other"></svg>`, false},
+		{`text <svg></svg>`, false},
+		{"\x89PNG\r\n\x1a\n", false},
+		{"<!-- " + strings.Repeat("x", svgSniffLimit) + " -->" + `<svg xmlns="http://www.w3.org/2000/svg">`, false}, // after svgSniffLimit
+	}
+	for _, l := range list {
+		if svg := isSVG(strings.NewReader(l.document)); svg != l.svg {
+			t.Errorf("isSVG(%.40q): Returned[%t]. Expected: %t", l.document, svg, l.svg)
+		}
+	}
+}
+
+func Test_readHEIFHeader(t *testing.T) {
+	width, height, err := readHEIFHeader(bytes.NewReader(testHEIC()))
+	if err != nil {
+		t.Fatalf("readHEIFHeader(): Returned an error! [%s]", err)
+	}
+	if width != 4032 || height != 3024 {
+		t.Errorf("readHEIFHeader(): Returned[%dx%d]. Expected: 4032x3024", width, height)
+	}
+
+	if _, _, err := readHEIFHeader(bytes.NewReader(testHEIC()[:40])); err == nil {
+		t.Errorf("readHEIFHeader(): Should return an error for a truncated file!")
+	}
+}
+
+func Test_readSVGHeader(t *testing.T) {
+	var list = []struct {
+		svg           string
+		width, height int
+	}{
+		{`<svg width="120" height="80"></svg>`, 120, 80},
+		{`<svg width="2in" height="1in"></svg>`, 192, 96},
+		{`<svg width="100%" height="100%" viewBox="0 0 640 480"></svg>`, 640, 480},
+		{`<svg width="320" viewBox="0,0,640,480"></svg>`, 320, 240},
+		{`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`, 300, 150},
+		{`<svg width="50000" height="50000"></svg>`, 50000, 50000}, // ImageLimits are checked afterwards
+	}
+	for _, l := range list {
+		width, height, err := readSVGHeader(strings.NewReader(l.svg))
+		if err != nil {
+			t.Errorf("readSVGHeader(%s): Returned an error! [%s]", l.svg, err)
+			continue
+		}
+		if width != l.width || height != l.height {
+			t.Errorf("readSVGHeader(%s): Returned[%dx%d]. Expected: %dx%d", l.svg, width, height, l.width, l.height)
+		}
+	}
+
+	if _, _, err := readSVGHeader(strings.NewReader(`<html></html>`)); err == nil {
+		t.Errorf("readSVGHeader(): Should return an error when the root element is not <svg>!")
+	}
+
+	// sizes that do not fit an int
+	for _, svg := range []string{`<svg width="1e300" height="1"></svg>`, `<svg width="1" height="3000000000"></svg>`, `<svg width="1e300" viewBox="0 0 1e-300 1"></svg>`} {
+		if _, _, err := readSVGHeader(strings.NewReader(svg)); errors.Cause(err) != ErrInvalidImageSize {
+			t.Errorf("readSVGHeader(%s): Returned[%v]. Expected[%s]", svg, err, ErrInvalidImageSize)
+		}
+	}
+}
+
+func Test_UploadImageWithThumbnail_formats(t *testing.T) {
+	tempDir1, tempDir2 := "testing-filevalidator-images", "testing-filevalidator-thumbnails"
+	dir1, err := ioutil.TempDir("", tempDir1) // make two temporary directories
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir2, err := ioutil.TempDir("", tempDir2)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir1) // delete the temp directories
+	defer os.RemoveAll(dir2)
+
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	for x := 0; x < 40; x++ {
+		img.Set(x, x%30, color.RGBA{200, 20, 20, 255})
+	}
+	bmpBuf, tiffBuf := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := bmp.Encode(bmpBuf, img); err != nil {
+		t.Fatalf("bmp.Encode: %s", err)
+	}
+	if err := tiff.Encode(tiffBuf, img, nil); err != nil {
+		t.Fatalf("tiff.Encode: %s", err)
+	}
+
+	req := setupRequestMultipartForm(&testFile{base64.StdEncoding.EncodeToString(bmpBuf.Bytes()), "imageupload", "scan.bmp"}, &testFile{base64.StdEncoding.EncodeToString(tiffBuf.Bytes()), "imageupload", "scan.tiff"})
+
+	for _, header := range req.MultipartForm.File["imageupload"] {
+		fi, err := UploadImageWithThumbnail(header, dir1, dir2)
+		if err != nil {
+			t.Errorf("UploadImageWithThumbnail(%s): Returned an error! [%s]", header.Filename, err)
+			continue
+		}
+		if fi.MimeType != "image/jpeg" || fi.Width != 40 || fi.Height != 30 {
+			t.Errorf("UploadImageWithThumbnail(%s): Returned[%s %dx%d]. Expected: image/jpeg 40x30", header.Filename, fi.MimeType, fi.Width, fi.Height)
+		}
+		if _, err := os.Stat(dir2 + string(os.PathSeparator) + fi.ThumbnailName); err != nil { // check if the thumbnail exists
+			t.Errorf("UploadImageWithThumbnail(%s): Thumbnail does not exist! [%s]", header.Filename, err)
+		}
+	}
+
+	// the size of an SVG image is checked before it is rasterized
+	defaultLimits := DefaultImageLimits
+	defer func() { DefaultImageLimits = defaultLimits }()
+	DefaultImageLimits = ImageLimits{MaxMegapixels: 10}
+
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="50000" height="50000"><rect width="10" height="10"/></svg>`
+	req = setupRequestMultipartForm(&testFile{base64.StdEncoding.EncodeToString([]byte(svg)), "imageupload", "huge.svg"})
+	if _, err := UploadImageWithThumbnail(req.MultipartForm.File["imageupload"][0], dir1, dir2); err == nil {
+		t.Errorf("UploadImageWithThumbnail(huge.svg): Should return an *ImageLimitError!")
+	} else if _, ok := err.(*ImageLimitError); !ok {
+		t.Errorf("UploadImageWithThumbnail(huge.svg): Should return an *ImageLimitError! Returned[%s]", err)
+	}
+}
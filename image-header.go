@@ -0,0 +1,317 @@
+package fileupload
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	_ "image/gif" // register decoders for image.DecodeConfig()
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime/multipart"
+
+	"github.com/pkg/errors" // external dependencies
+	_ "golang.org/x/image/webp"
+)
+
+/*
+	Limits checked against the image header before any decoding happens, a zero value disables a limit
+	Protects against decompression bombs, small files that declare huge dimensions
+*/
+type ImageLimits struct {
+	MaxBytes      int64
+	MaxWidth      int
+	MaxHeight     int
+	MaxMegapixels float64
+	MaxFrames     int
+}
+
+// used by UploadImageWithThumbnail() and UploadAllImages(), can be changed by the caller
+var DefaultImageLimits = ImageLimits{MaxBytes: 50 << 20, MaxWidth: 16384, MaxHeight: 16384, MaxMegapixels: 100, MaxFrames: 1000}
+
+var ErrInvalidImageSize = errors.New("The image does not have a valid size!")
+
+// returned when an image is over one of the ImageLimits
+type ImageLimitError struct {
+	Limit string // "bytes", "width", "height", "megapixels" or "frames"
+	Value float64
+	Max   float64
+}
+
+func (e *ImageLimitError) Error() string {
+	return fmt.Sprintf("The image exceeds the maximum %s! [%g > %g]", e.Limit, e.Value, e.Max)
+}
+
+// information read from the start of an image file, without decoding the pixels
+type imageHeader struct {
+	Format   string // "jpeg", "png", "gif", "webp", "bmp", "tiff", "heif" or "svg"
+	Width    int
+	Height   int
+	Frames   int
+	Duration int // milliseconds, for animations
+}
+
+// check the image header against the limits, returns an *ImageLimitError. An image without a width or height returns ErrInvalidImageSize
+func (l ImageLimits) check(size int64, h *imageHeader) error {
+	if l.MaxBytes > 0 && size > l.MaxBytes {
+		return &ImageLimitError{"bytes", float64(size), float64(l.MaxBytes)}
+	}
+	if h == nil {
+		return nil
+	}
+	if h.Width <= 0 || h.Height <= 0 { // never within the limits, a size that did not fit an int would pass all of them
+		return ErrInvalidImageSize
+	}
+	if l.MaxWidth > 0 && h.Width > l.MaxWidth {
+		return &ImageLimitError{"width", float64(h.Width), float64(l.MaxWidth)}
+	}
+	if l.MaxHeight > 0 && h.Height > l.MaxHeight {
+		return &ImageLimitError{"height", float64(h.Height), float64(l.MaxHeight)}
+	}
+	if mp := float64(h.Width) * float64(h.Height) / 1e6; l.MaxMegapixels > 0 && mp > l.MaxMegapixels {
+		return &ImageLimitError{"megapixels", mp, l.MaxMegapixels}
+	}
+	if l.MaxFrames > 0 && h.Frames > l.MaxFrames {
+		return &ImageLimitError{"frames", float64(h.Frames), float64(l.MaxFrames)}
+	}
+
+	return nil
+}
+
+/*
+	Read the dimensions and frame count of an image, only the header and block structure are read
+	maxFrames - stop counting frames once this number is exceeded, 0 counts all of them
+*/
+func readImageHeader(file multipart.File, maxFrames int) (*imageHeader, error) {
+	if _, err := file.Seek(0, 0); err != nil { // set position to the start of the file
+		return nil, errors.Wrap(err, "readImageHeader()")
+	}
+
+	buffer := make([]byte, 512)
+	n, err := io.ReadFull(file, buffer)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, errors.Wrap(err, "readImageHeader()")
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		return nil, errors.Wrap(err, "readImageHeader()")
+	}
+
+	mimetype := sniffImageType(buffer[:n])
+	if svg, err := sniffSVG(file); err != nil {
+		return nil, errors.Wrap(err, "readImageHeader()")
+	} else if svg {
+		mimetype = "image/svg+xml"
+	}
+
+	var config image.Config
+	var format string
+	switch mimetype { // formats without a Go decoder
+	case "image/heic", "image/heif":
+		format = "heif"
+		config.Width, config.Height, err = readHEIFHeader(file)
+	case "image/svg+xml":
+		format = "svg"
+		config.Width, config.Height, err = readSVGHeader(file)
+	default:
+		config, format, err = image.DecodeConfig(file)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "readImageHeader()")
+	}
+
+	if _, err := file.Seek(0, 0); err != nil {
+		return nil, errors.Wrap(err, "readImageHeader()")
+	}
+
+	h := &imageHeader{Format: format, Width: config.Width, Height: config.Height, Frames: 1}
+	switch format {
+	case "gif":
+		h.Frames, h.Duration, err = readGIFAnimation(bufio.NewReader(file), maxFrames)
+	case "png":
+		h.Frames, h.Duration, err = readPNGAnimation(bufio.NewReader(file), maxFrames)
+	case "webp":
+		h.Frames, h.Duration, err = readWebPAnimation(bufio.NewReader(file), maxFrames)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "readImageHeader()")
+	}
+
+	if _, err := file.Seek(0, 0); err != nil { // reset position to start of the file
+		return nil, errors.Wrap(err, "readImageHeader()")
+	}
+
+	return h, nil
+}
+
+// walk the GIF block structure, count the image descriptors and add up the frame delays, the LZW data is skipped
+func readGIFAnimation(r *bufio.Reader, maxFrames int) (frames, duration int, err error) {
+	header := make([]byte, 13) // signature, version and logical screen descriptor
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, 0, err
+	}
+	if header[10]&0x80 != 0 { // global color table
+		if _, err := r.Discard(3 << ((header[10] & 0x07) + 1)); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, err
+		}
+
+		switch b {
+		case 0x21: // extension: label followed by data sub-blocks
+			label, err := r.ReadByte()
+			if err != nil {
+				return 0, 0, err
+			}
+			if label == 0xF9 { // graphic control extension, the delay is in hundredths of a second
+				control := make([]byte, 5)
+				if _, err := io.ReadFull(r, control); err != nil {
+					return 0, 0, err
+				}
+				duration += int(binary.LittleEndian.Uint16(control[2:4])) * 10
+			}
+			if err := skipGIFSubBlocks(r); err != nil {
+				return 0, 0, err
+			}
+		case 0x2C: // image descriptor
+			frames++
+			if maxFrames > 0 && frames > maxFrames {
+				return frames, duration, nil
+			}
+			descriptor := make([]byte, 9)
+			if _, err := io.ReadFull(r, descriptor); err != nil {
+				return 0, 0, err
+			}
+			if descriptor[8]&0x80 != 0 { // local color table
+				if _, err := r.Discard(3 << ((descriptor[8] & 0x07) + 1)); err != nil {
+					return 0, 0, err
+				}
+			}
+			if _, err := r.ReadByte(); err != nil { // LZW minimum code size
+				return 0, 0, err
+			}
+			if err := skipGIFSubBlocks(r); err != nil {
+				return 0, 0, err
+			}
+		case 0x3B: // trailer
+			return frames, duration, nil
+		default:
+			return 0, 0, errors.Errorf("readGIFAnimation(): Unknown block [0x%02x]", b)
+		}
+	}
+}
+
+func skipGIFSubBlocks(r *bufio.Reader) error {
+	for {
+		size, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			return nil
+		}
+		if _, err := r.Discard(int(size)); err != nil {
+			return err
+		}
+	}
+}
+
+/*
+	An animated PNG declares the number of frames in the acTL chunk, which comes before the first IDAT chunk
+	Each frame has a fcTL chunk with its delay
+*/
+func readPNGAnimation(r *bufio.Reader, maxFrames int) (frames, duration int, err error) {
+	if _, err := r.Discard(8); err != nil { // signature
+		return 0, 0, err
+	}
+
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return 0, 0, err
+		}
+		length := int(binary.BigEndian.Uint32(chunk[:4]))
+
+		switch string(chunk[4:]) {
+		case "acTL":
+			data := make([]byte, 4)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return 0, 0, err
+			}
+			frames = int(binary.BigEndian.Uint32(data))
+			if maxFrames > 0 && frames > maxFrames {
+				return frames, 0, nil
+			}
+			length -= 4
+		case "fcTL":
+			data := make([]byte, 24)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return 0, 0, err
+			}
+			delayNum, delayDen := int(binary.BigEndian.Uint16(data[20:22])), int(binary.BigEndian.Uint16(data[22:24]))
+			if delayDen == 0 { // the specification treats 0 as 100
+				delayDen = 100
+			}
+			duration += delayNum * 1000 / delayDen
+			length -= 24
+		case "IDAT":
+			if frames == 0 { // not animated, no need to read the image data
+				return 1, 0, nil
+			}
+		case "IEND":
+			if frames == 0 {
+				frames = 1
+			}
+			return frames, duration, nil
+		}
+
+		if _, err := r.Discard(length + 4); err != nil { // rest of the chunk data and CRC
+			return 0, 0, err
+		}
+	}
+}
+
+// count the ANMF chunks of an animated WebP file and add up their durations
+func readWebPAnimation(r *bufio.Reader, maxFrames int) (frames, duration int, err error) {
+	if _, err := r.Discard(12); err != nil { // "RIFF", file size, "WEBP"
+		return 0, 0, err
+	}
+
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, chunk); err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, 0, err
+		}
+		length := int(binary.LittleEndian.Uint32(chunk[4:]))
+		length += length & 1 // chunks are padded to an even size
+
+		if string(chunk[:4]) == "ANMF" {
+			data := make([]byte, 16)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return 0, 0, err
+			}
+			frames++
+			if maxFrames > 0 && frames > maxFrames {
+				return frames, duration, nil
+			}
+			duration += int(data[12]) | int(data[13])<<8 | int(data[14])<<16
+			length -= 16
+		}
+
+		if _, err := r.Discard(length); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if frames == 0 {
+		frames = 1
+	}
+	return frames, duration, nil
+}
@@ -0,0 +1,137 @@
+package fileupload
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/gif"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// build an animated GIF in memory, returned as a base64 string for setupRequestMultipartForm()
+func animatedGIFBase64(frames int) string {
+	palette := color.Palette{color.Black, color.White}
+	anim := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		img := image.NewPaletted(image.Rect(0, 0, 8, 8), palette)
+		img.SetColorIndex(i%8, i%8, 1)
+		anim.Image = append(anim.Image, img)
+		anim.Delay = append(anim.Delay, 10)
+	}
+
+	buf := &bytes.Buffer{}
+	gif.EncodeAll(buf, anim)
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func Test_readImageHeader(t *testing.T) {
+	var list = []struct {
+		tf       *testFile
+		format   string
+		width    int
+		height   int
+		frames   int
+		duration int
+	}{
+		{&testFile{gopherPNG, "imageupload", "gopher.png"}, "png", 250, 340, 1, 0},
+		{&testFile{blueJPG, "imageupload", "blue.jpg"}, "jpeg", 300, 163, 1, 0},
+		{&testFile{animatedGIFBase64(5), "imageupload", "anim.gif"}, "gif", 8, 8, 5, 500},
+	}
+
+	for _, l := range list {
+		req := setupRequestMultipartForm(l.tf)
+		header := req.MultipartForm.File["imageupload"][0]
+		file, err := header.Open()
+		if err != nil {
+			t.Fatalf("Failed to open *multipart.FileHeader: %s", err)
+		}
+		defer file.Close()
+
+		h, err := readImageHeader(file, 0)
+		if err != nil {
+			t.Errorf("readImageHeader(%s): Returned an error! [%s]", l.tf.filename, err)
+			continue
+		}
+		if h.Format != l.format || h.Width != l.width || h.Height != l.height || h.Frames != l.frames || h.Duration != l.duration {
+			t.Errorf("readImageHeader(%s): Returned[%+v]. Expected: %s %dx%d %d frames %dms", l.tf.filename, *h, l.format, l.width, l.height, l.frames, l.duration)
+		}
+	}
+}
+
+func Test_ImageLimits_check(t *testing.T) {
+	h := &imageHeader{Format: "png", Width: 50000, Height: 50000, Frames: 1}
+	var list = []struct {
+		limits ImageLimits
+		limit  string // empty when no error is expected
+	}{
+		{ImageLimits{}, ""},
+		{ImageLimits{MaxBytes: 100}, "bytes"},
+		{ImageLimits{MaxWidth: 16384}, "width"},
+		{ImageLimits{MaxHeight: 16384}, "height"},
+		{ImageLimits{MaxMegapixels: 100}, "megapixels"},
+		{ImageLimits{MaxFrames: 0, MaxWidth: 50000, MaxHeight: 50000}, ""},
+	}
+
+	for _, l := range list {
+		err := l.limits.check(1000, h)
+		if l.limit == "" {
+			if err != nil {
+				t.Errorf("ImageLimits.check(%+v): Returned an error! [%s]", l.limits, err)
+			}
+			continue
+		}
+
+		limitErr, ok := err.(*ImageLimitError)
+		if !ok {
+			t.Errorf("ImageLimits.check(%+v): Should return an *ImageLimitError! Returned[%v]", l.limits, err)
+			continue
+		}
+		if limitErr.Limit != l.limit {
+			t.Errorf("ImageLimits.check(%+v): Returned limit[%s]. Expected: %s", l.limits, limitErr.Limit, l.limit)
+		}
+	}
+
+	// an image without a size is never within the limits
+	for _, h := range []*imageHeader{{Width: 0, Height: 10}, {Width: 10, Height: -1}, {Width: -9223372036854775808, Height: 1}} {
+		if err := DefaultImageLimits.check(1000, h); err != ErrInvalidImageSize {
+			t.Errorf("ImageLimits.check(%dx%d): Returned[%v]. Expected[%s]", h.Width, h.Height, err, ErrInvalidImageSize)
+		}
+	}
+}
+
+func Test_UploadImageWithThumbnail_limits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testing-filevalidator") // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	defaultLimits := DefaultImageLimits
+	defer func() { DefaultImageLimits = defaultLimits }()
+
+	var list = []struct {
+		limits ImageLimits
+		tf     *testFile
+	}{
+		{ImageLimits{MaxWidth: 100}, &testFile{gopherPNG, "imageupload", "gopher.png"}},
+		{ImageLimits{MaxMegapixels: 0.01}, &testFile{blueJPG, "imageupload", "blue.jpg"}},
+		{ImageLimits{MaxFrames: 3}, &testFile{animatedGIFBase64(5), "imageupload", "anim.gif"}},
+	}
+
+	for _, l := range list {
+		DefaultImageLimits = l.limits
+		req := setupRequestMultipartForm(l.tf)
+
+		_, err := UploadImageWithThumbnail(req.MultipartForm.File["imageupload"][0], dir, dir)
+		if _, ok := err.(*ImageLimitError); !ok {
+			t.Errorf("UploadImageWithThumbnail(%s): Should return an *ImageLimitError! Returned[%v]", l.tf.filename, err)
+		}
+	}
+
+	if files := uploadDirEntries(t, dir); len(files) != 0 { // nothing should be saved
+		t.Errorf("UploadImageWithThumbnail(): Files were saved after exceeding the limits! [%d]", len(files))
+	}
+}
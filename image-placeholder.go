@@ -0,0 +1,185 @@
+package fileupload
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/pkg/errors" // external dependencies
+	"gopkg.in/h2non/bimg.v1"
+)
+
+const (
+	blurHashComponentsX = 4
+	blurHashComponentsY = 3
+	placeholderWidth    = 16 // width of the base64 preview, FileInfo.Placeholder
+	sampleWidth         = 32 // width of the copy used for the BlurHash and the dominant color
+)
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+/*
+	Set FileInfo.BlurHash, FileInfo.Placeholder and FileInfo.DominantColor, shown by the browser while the thumbnail loads
+	The image in the buffer is only scaled down once to a tiny copy, which is used for everything
+*/
+func setPlaceholders(fi *FileInfo, buffer []byte, width, height int) error {
+	sampleHeight := int(math.Max(1, math.Floor((float64(sampleWidth)*float64(height)/float64(width))+0.5)))
+	sample, err := bimg.NewImage(buffer).Process(bimg.Options{Type: bimg.PNG, Width: sampleWidth, Height: sampleHeight, Force: true})
+	if err != nil {
+		return errors.Wrap(err, "setPlaceholders()")
+	}
+	img, _, err := image.Decode(bytes.NewReader(sample))
+	if err != nil {
+		return errors.Wrap(err, "setPlaceholders()")
+	}
+
+	previewHeight := int(math.Max(1, math.Floor((float64(placeholderWidth)*float64(height)/float64(width))+0.5)))
+	preview, err := bimg.NewImage(sample).Process(bimg.Options{Type: bimg.JPEG, Quality: 40, Width: placeholderWidth, Height: previewHeight, Force: true})
+	if err != nil {
+		return errors.Wrap(err, "setPlaceholders()")
+	}
+
+	fi.BlurHash = blurHash(img, blurHashComponentsX, blurHashComponentsY)
+	fi.Placeholder = "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(preview)
+	fi.DominantColor = dominantColor(img)
+
+	return nil
+}
+
+// encode an image as a BlurHash string, see https://github.com/woltapp/blurhash for the algorithm
+func blurHash(img image.Image, componentsX, componentsY int) string {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// the image as linear RGB, converting once instead of for every component
+	linear := make([][3]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			linear[y*width+x] = [3]float64{sRGBToLinear(int(r >> 8)), sRGBToLinear(int(g >> 8)), sRGBToLinear(int(b >> 8))}
+		}
+	}
+
+	factors := make([][3]float64, 0, componentsX*componentsY)
+	for j := 0; j < componentsY; j++ {
+		for i := 0; i < componentsX; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1.0
+			}
+
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := normalisation * math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) * math.Cos(math.Pi*float64(j)*float64(y)/float64(height))
+					pixel := linear[y*width+x]
+					factor[0] += basis * pixel[0]
+					factor[1] += basis * pixel[1]
+					factor[2] += basis * pixel[2]
+				}
+			}
+			scale := 1.0 / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	hash := encodeBase83((componentsX-1)+(componentsY-1)*9, 1)
+
+	maximumValue := 1.0
+	if len(factors) > 1 {
+		actualMaximum := 0.0
+		for _, f := range factors[1:] {
+			actualMaximum = math.Max(actualMaximum, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantisedMaximum := int(math.Max(0, math.Min(82, math.Floor(actualMaximum*166-0.5))))
+		maximumValue = float64(quantisedMaximum+1) / 166
+		hash += encodeBase83(quantisedMaximum, 1)
+	} else {
+		hash += encodeBase83(0, 1)
+	}
+
+	dc := factors[0]
+	hash += encodeBase83((linearToSRGB(dc[0])<<16)+(linearToSRGB(dc[1])<<8)+linearToSRGB(dc[2]), 4)
+
+	for _, f := range factors[1:] {
+		quant := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximumValue, 0.5)*9+9.5))))
+		}
+		hash += encodeBase83(quant(f[0])*19*19+quant(f[1])*19+quant(f[2]), 2)
+	}
+
+	return hash
+}
+
+func encodeBase83(value, length int) string {
+	result := make([]byte, length)
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		result[i-1] = base83Chars[digit]
+	}
+	return string(result)
+}
+
+func sRGBToLinear(value int) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
+
+/*
+	The most common color of an image as "#rrggbb"
+	Colors are grouped by the top 4 bits of each channel, the result is the average color of the largest group
+*/
+func dominantColor(img image.Image) string {
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	buckets := make(map[int]*bucket)
+	var largest *bucket
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			if a < 0x8000 { // mostly transparent pixels are not part of the image's color
+				continue
+			}
+			r, g, b = r>>8, g>>8, b>>8
+			key := int(r>>4)<<8 | int(g>>4)<<4 | int(b>>4)
+
+			bk, ok := buckets[key]
+			if !ok {
+				bk = &bucket{}
+				buckets[key] = bk
+			}
+			bk.count++
+			bk.r, bk.g, bk.b = bk.r+int(r), bk.g+int(g), bk.b+int(b)
+
+			if largest == nil || bk.count > largest.count {
+				largest = bk
+			}
+		}
+	}
+
+	if largest == nil { // completely transparent
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", largest.r/largest.count, largest.g/largest.count, largest.b/largest.count)
+}
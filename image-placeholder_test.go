@@ -0,0 +1,91 @@
+package fileupload
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_encodeBase83(t *testing.T) {
+	var list = []struct {
+		value  int
+		length int
+		exp    string
+	}{
+		{0, 1, "0"},
+		{21, 1, "L"},
+		{82, 1, "~"},
+		{83, 2, "10"},
+		{3429, 2, "fQ"},
+	}
+	for _, l := range list {
+		if str := encodeBase83(l.value, l.length); str != l.exp {
+			t.Errorf("encodeBase83(%d, %d): Returned[%s]. Expected: %s", l.value, l.length, str, l.exp)
+		}
+	}
+}
+
+func Test_blurHash(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 24))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{255, 0, 0, 255}), image.Point{}, draw.Src)
+
+	hash := blurHash(img, 4, 3)
+	if len(hash) != 28 || hash[0] != 'L' { // 4x3 components: 1+1+4+2*11 characters, "L" is the size flag
+		t.Errorf("blurHash(): Returned[%s]. Expected 28 characters starting with \"L\"", hash)
+	}
+	if hash[2:6] != encodeBase83(0xff0000, 4) { // the DC component is the average color
+		t.Errorf("blurHash(): Returned[%s]. Wrong average color [%s]", hash, hash[2:6])
+	}
+	if hash2 := blurHash(img, 1, 1); hash2 != "00"+encodeBase83(0xff0000, 4) { // no AC components
+		t.Errorf("blurHash(): Returned[%s]. Expected: 00%s", hash2, encodeBase83(0xff0000, 4))
+	}
+}
+
+func Test_dominantColor(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{0x20, 0x40, 0x80, 0xff}), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, 3, 3), image.NewUniform(color.RGBA{0xff, 0xff, 0xff, 0xff}), image.Point{}, draw.Src)
+
+	if c := dominantColor(img); c != "#204080" {
+		t.Errorf("dominantColor(): Returned[%s]. Expected: #204080", c)
+	}
+	if c := dominantColor(image.NewRGBA(image.Rect(0, 0, 10, 10))); c != "" { // transparent
+		t.Errorf("dominantColor(): Returned[%s]. Expected an empty string", c)
+	}
+}
+
+func Test_UploadImageWithThumbnail_placeholders(t *testing.T) {
+	tempDir1, tempDir2 := "testing-filevalidator-images", "testing-filevalidator-thumbnails"
+	dir1, err := ioutil.TempDir("", tempDir1) // make two temporary directories
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir2, err := ioutil.TempDir("", tempDir2)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir1) // delete the temp directories
+	defer os.RemoveAll(dir2)
+
+	req := setupRequestMultipartForm(&testFile{blueJPG, "imageupload", "blue.jpg"})
+
+	fi, err := UploadImageWithThumbnail(req.MultipartForm.File["imageupload"][0], dir1, dir2)
+	if err != nil {
+		t.Fatalf("UploadImageWithThumbnail(): Returned an error! [%s]", err)
+	}
+	if len(fi.BlurHash) != 28 || !strings.HasPrefix(fi.Placeholder, "data:image/jpeg;base64,") || len(fi.DominantColor) != 7 {
+		t.Errorf("UploadImageWithThumbnail(): Missing placeholders! BlurHash[%s] Placeholder[%s] DominantColor[%s]", fi.BlurHash, fi.Placeholder, fi.DominantColor)
+	}
+
+	js, err := fi.Json()
+	if err != nil {
+		t.Errorf("FileInfo.Json(): Returned an error! [%s]", err)
+	}
+	if !strings.Contains(js, `"blurhash":"`+fi.BlurHash+`"`) {
+		t.Errorf("FileInfo.Json(): BlurHash is missing! [%s]", js)
+	}
+}
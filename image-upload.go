@@ -2,43 +2,133 @@ package fileupload
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"math"
 	"mime/multipart"
 	"os"
+	"path/filepath"
+	"strings"
 
-	"gopkg.in/h2non/bimg.v1" // external dependencies
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"gopkg.in/h2non/bimg.v1" // external dependencies
 )
 
 /*
-	Uses the Bimg library to save a thumbnail of a large image
+	Options for images saved by UploadImageWithThumbnail() and UploadAllImages()
+
+	MaxWidth, MaxHeight - bounding box for the saved image, larger images are scaled down preserving the aspect ratio, 0 is no limit
+	ArchiveDir - if set, the untouched uploaded file is also saved to this directory (same UUID, original extension)
+	ThumbnailWidth, ThumbnailHeight - size of the thumbnail, when both are 0 thumbnails are 75 pixels high with the aspect ratio of the image
+	Crop - how the thumbnail is fitted to its size, see CropMode
+	FocalPoint - used by CropFocalPoint, for example the center of a face
+	Animation - how animated images are saved, the first frame as a jpeg by default. The thumbnail is always a still jpeg of the first frame
+	NoPlaceholders - skip the BlurHash, Placeholder and DominantColor fields of FileInfo
+	Owner - the saved image counts towards the storage quota of the owner, see DefaultQuota. Thumbnails and archived originals are not counted
+	SetURLs - fill in the URLs of the saved image and its thumbnail, DefaultURLBuilder when nil
+	Jobs - make the thumbnail in the background, the image is returned with Processing set to JobPending and the thumbnail shows up later. Not for animations saved as MP4, their thumbnail is made right away
+	ClientKey, Context - reading the upload is limited by DefaultThrottle for this client, see UploadOptions
 */
-func saveThumbnail(buffer []byte, path, name string, width, height int) error {
-	widthRatio := float64(width) / float64(height)
-	thumbHeight := 75
-	thumbWidth := int(math.Floor((float64(thumbHeight) * widthRatio) + 0.5)) // round the float
-	options := bimg.Options{Quality: 90, Type: bimg.JPEG, Width: thumbWidth, Height: thumbHeight}
+type ImageOptions struct {
+	MaxWidth        int
+	MaxHeight       int
+	ArchiveDir      string
+	ThumbnailWidth  int
+	ThumbnailHeight int
+	Crop            CropMode
+	FocalPoint      FocalPoint
+	Animation       AnimationFormat
+	NoPlaceholders  bool
+	Owner           string
+	SetURLs         func(fi *FileInfo)
+	Jobs            *JobQueue
+	ClientKey       string
+	Context         context.Context
+}
+
+// used by UploadImageWithThumbnail() and UploadAllImages(), can be changed by the caller
+var DefaultImageOptions = ImageOptions{}
+
+// scale width and height down to fit inside the bounding box, images are never enlarged
+func fitBoundingBox(width, height, maxWidth, maxHeight int) (int, int) {
+	scale := 1.0
+	if maxWidth > 0 && width > maxWidth {
+		scale = math.Min(scale, float64(maxWidth)/float64(width))
+	}
+	if maxHeight > 0 && height > maxHeight {
+		scale = math.Min(scale, float64(maxHeight)/float64(height))
+	}
+	if scale == 1.0 {
+		return width, height
+	}
+
+	newWidth := int(math.Max(1, math.Floor((float64(width)*scale)+0.5))) // round the float, at least 1 pixel
+	newHeight := int(math.Max(1, math.Floor((float64(height)*scale)+0.5)))
+	return newWidth, newHeight
+}
 
-	newImage, err := bimg.NewImage(buffer).Process(options) // do image parsing
+/*
+	Uses the Bimg library to save a thumbnail of a large image, an existing file returns ErrFileExists and is kept
+	width, height - size of the image in the buffer
+*/
+func saveThumbnail(buffer []byte, path, name string, width, height int, options ImageOptions) error {
+	newImage, err := makeThumbnail(buffer, width, height, options)
 	if err != nil {
-		return errors.Wrap(err, "saveThumbnail()")
+		return err
 	}
-	err = bimg.Write(path+string(os.PathSeparator)+name, newImage) // save image to a file
+	root, err := OpenRoot(path)
 	if err != nil {
 		return errors.Wrap(err, "saveThumbnail()")
 	}
+	defer root.Close()
+	if err := root.writeNewFile(name, newImage, 0644); err == ErrFileExists { // save image to a file
+		return err
+	} else if err != nil {
+		return errors.Wrap(err, "saveThumbnail()")
+	}
 
 	return nil
 }
 
+// the jpeg bytes of a thumbnail, see saveThumbnail()
+func makeThumbnail(buffer []byte, width, height int, options ImageOptions) ([]byte, error) {
+	thumbWidth, thumbHeight := thumbnailSize(width, height, options)
+	thumbOptions := bimg.Options{Quality: 90, Type: bimg.JPEG, Width: thumbWidth, Height: thumbHeight, Background: bimg.Color{R: 255, G: 255, B: 255}}
+
+	switch options.Crop {
+	case CropCenter:
+		thumbOptions.Crop, thumbOptions.Gravity = true, bimg.GravityCentre
+	case CropSmart:
+		thumbOptions.Crop, thumbOptions.Gravity = true, bimg.GravitySmart
+	case CropFocalPoint: // cut the area around the focal point, then scale it to the thumbnail size
+		left, top, areaWidth, areaHeight := focalCropArea(width, height, thumbWidth, thumbHeight, options.FocalPoint)
+		area, err := bimg.NewImage(buffer).Extract(top, left, areaWidth, areaHeight)
+		if err != nil {
+			return nil, errors.Wrap(err, "makeThumbnail()")
+		}
+		buffer = area
+		thumbOptions.Force = true // the area already has the aspect ratio of the thumbnail
+	default:
+		if options.ThumbnailWidth > 0 || options.ThumbnailHeight > 0 {
+			thumbOptions.Force = true // the aspect ratio is already kept by thumbnailSize()
+		}
+	}
+
+	newImage, err := bimg.NewImage(buffer).Process(thumbOptions) // do image parsing
+	if err != nil {
+		return nil, errors.Wrap(err, "makeThumbnail()")
+	}
+	return newImage, nil
+}
+
 /*
 	Uses the Bimg library to save a copy of an image
+	Images larger than maxWidth/maxHeight are scaled down to fit, 0 is no limit
 	Returns data about the image (filesize, width, height, name, ...)
 */
-func saveImage(buffer []byte, oldName, newName, directory string) (*FileInfo, error) {
-	options := bimg.Options{Quality: 90, Type: bimg.JPEG}
+func saveImage(buffer []byte, oldName, newName, directory string, maxWidth, maxHeight int) (*FileInfo, error) {
+	options := bimg.Options{Quality: 90, Type: bimg.JPEG, Background: bimg.Color{R: 255, G: 255, B: 255}} // transparent areas become white
 
 	img := bimg.NewImage(buffer)
 	imgSize, err := img.Size()
@@ -46,17 +136,28 @@ func saveImage(buffer []byte, oldName, newName, directory string) (*FileInfo, er
 		return nil, errors.Wrap(err, "saveImage()")
 	}
 
+	if width, height := fitBoundingBox(imgSize.Width, imgSize.Height, maxWidth, maxHeight); width != imgSize.Width || height != imgSize.Height {
+		options.Width, options.Height, options.Force = width, height, true // the aspect ratio is already kept by fitBoundingBox()
+		imgSize.Width, imgSize.Height = width, height
+	}
+
 	newImage, err := img.Process(options) // do image parsing
 	if err != nil {
 		return nil, errors.Wrap(err, "saveImage()")
 	}
-	err = bimg.Write(directory+string(os.PathSeparator)+newName, newImage) // save image to a file
+	root, err := OpenRoot(directory)
 	if err != nil {
 		return nil, errors.Wrap(err, "saveImage()")
 	}
+	defer root.Close()
+	if err := root.writeNewFile(newName, newImage, 0644); err == ErrFileExists { // save image to a file
+		return nil, err
+	} else if err != nil {
+		return nil, errors.Wrap(err, "saveImage()")
+	}
 
 	var size int64
-	if fi, err := os.Stat(directory + string(os.PathSeparator) + newName); err != nil {
+	if fi, err := root.Stat(newName); err != nil {
 		return nil, errors.Wrap(err, "saveImage()")
 	} else {
 		size = fi.Size()
@@ -65,7 +166,28 @@ func saveImage(buffer []byte, oldName, newName, directory string) (*FileInfo, er
 	return &FileInfo{Name: newName, OriginalName: oldName, Size: size, MimeType: "image/jpeg", IsImage: true, Directory: directory, Width: imgSize.Width, Height: imgSize.Height}, nil
 }
 
+// a file written by UploadImageWithOptions()
+type savedFile struct {
+	directory, name string
+}
+
 func UploadImageWithThumbnail(header *multipart.FileHeader, imageDir string, thumbnailDir string) (*FileInfo, error) {
+	return UploadImageWithOptions(header, imageDir, thumbnailDir, DefaultImageOptions)
+}
+
+/*
+	Same as UploadImageWithThumbnail(), with options for this one image instead of DefaultImageOptions
+	Example, a square avatar centered on a face:
+	UploadImageWithOptions(header, "avatars", "avatar-thumbnails", ImageOptions{ThumbnailWidth: 128, ThumbnailHeight: 128, Crop: CropFocalPoint, FocalPoint: FocalPoint{0.4, 0.3}})
+*/
+func UploadImageWithOptions(header *multipart.FileHeader, imageDir, thumbnailDir string, options ImageOptions) (fi *FileInfo, err error) {
+	event := &UploadEvent{Header: header, Directory: imageDir, Owner: options.Owner}
+	defer func() {
+		if err != nil {
+			DefaultHooks.onError(event, err)
+		}
+	}()
+
 	// check if the directories exist
 	if _, err := os.Stat(imageDir); os.IsNotExist(err) {
 		return nil, ErrDirectoryDoesNotExist
@@ -73,38 +195,186 @@ func UploadImageWithThumbnail(header *multipart.FileHeader, imageDir string, thu
 	if _, err := os.Stat(thumbnailDir); os.IsNotExist(err) {
 		return nil, ErrDirectoryDoesNotExist
 	}
+	if len(options.ArchiveDir) > 0 {
+		if _, err := os.Stat(options.ArchiveDir); os.IsNotExist(err) {
+			return nil, ErrDirectoryDoesNotExist
+		}
+	}
 
 	file, err := header.Open()
 	if err != nil {
-		return nil, errors.Wrap(err, "UploadImageWithThumbnail()")
+		return nil, errors.Wrap(err, "UploadImageWithOptions()")
 	}
 	defer file.Close()
 
 	// is it an image?
 	mimetype, err := getMimeType(file)
 	if err != nil {
-		return nil, errors.Wrap(err, "UploadImageWithThumbnail()")
+		return nil, errors.Wrap(err, "UploadImageWithOptions()")
 	}
+	event.MimeType = mimetype
 	if isFileImage(mimetype) == false {
 		return nil, ErrNotImageType
 	}
 
-	// copy file to a buffer
-	buffer := &bytes.Buffer{}
-	if _, err := io.Copy(buffer, file); err != nil {
-		return nil, errors.Wrap(err, "UploadImageWithThumbnail()")
+	// check the size and the image header before any decoding, see DefaultImageLimits
+	if err := DefaultImageLimits.check(header.Size, nil); err != nil {
+		return nil, err
+	}
+	imgHeader, err := readImageHeader(file, DefaultImageLimits.MaxFrames)
+	if err != nil {
+		return nil, errors.Wrap(err, "UploadImageWithOptions()")
+	}
+	if err := DefaultImageLimits.check(header.Size, imgHeader); err != nil {
+		return nil, err
+	}
+	if err := checkQuota(options.Owner, header.Size); err != nil {
+		return nil, err
 	}
 
 	// UUIDv4 is used to avoid name conflicts (filename already exists errors)
+	// hooks can rename the image, the thumbnail and the archived original get the same name. The extension is that of the saved format
 	uuidStr := uuid.New().String() + ".jpg"
+	event.Name = uuidStr
+	if err := DefaultHooks.beforeUpload(event); err != nil {
+		return nil, err
+	}
+	event.Directory = imageDir // only the name can be changed
+	animated := imgHeader.Frames > 1 && options.Animation != AnimationFlatten
+	renamed := event.Name != uuidStr
+	if renamed { // renamed by a hook, the names of all the files that will be saved are checked
+		uuidStr = strings.TrimSuffix(event.Name, filepath.Ext(event.Name)) + ".jpg"
+		imageName := uuidStr
+		if animated {
+			imageName = strings.TrimSuffix(uuidStr, ".jpg") + "." + animationExtension(imgHeader, options.Animation)
+		}
+		if err := checkNewName(imageDir, imageName); err != nil {
+			return nil, err
+		}
+		if err := checkNewName(thumbnailDir, uuidStr); err != nil {
+			return nil, err
+		}
+		if len(options.ArchiveDir) > 0 {
+			if err := checkNewName(options.ArchiveDir, strings.TrimSuffix(uuidStr, ".jpg")+"."+getFileExtension(header.Filename)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	uuidBase := strings.TrimSuffix(uuidStr, ".jpg")
+
+	// copy file to a buffer, the bytes are reserved in the quota of the owner while they are read
+	quotaReader := newQuotaReader(options.Owner, file)
+	defer func() {
+		if err != nil {
+			quotaReader.settle(0)
+		}
+	}()
+	// the files saved so far are removed again when a later step fails, before the quota is released
+	var saved []savedFile
+	defer func() {
+		if err != nil {
+			for _, s := range saved {
+				removeFromDirectory(s.directory, s.name)
+				removeUploadRecord(s.directory, s.name) // written by recordOwner()
+			}
+		}
+	}()
+	reader := throttleUpload(options.Context, options.ClientKey, quotaReader)
+	buffer := &bytes.Buffer{}
+	if _, err := io.Copy(buffer, reader); err != nil {
+		if err == ErrQuotaExceeded {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "UploadImageWithOptions()")
+	}
+	original := buffer.Bytes()
+
+	if err := scanBuffer(original, uuidBase+"."+getFileExtension(header.Filename)); err != nil { // nothing is written for infected files
+		if infected, ok := err.(*InfectedError); ok {
+			return infectedFileInfo(infected, uuidStr, header.Filename, mimetype), infected
+		}
+		return nil, errors.Wrap(err, "UploadImageWithOptions()")
+	}
 
-	fi, err := saveImage(buffer.Bytes(), header.Filename, uuidStr, imageDir) // re-save the uploaded image
+	// sanitize SVG, convert BMP
+	prepared, err := prepareImageBuffer(original, imgHeader.Format)
 	if err != nil {
-		return nil, errors.Wrap(err, "UploadImageWithThumbnail()")
+		return nil, errors.Wrap(err, "UploadImageWithOptions()")
 	}
-	if err := saveThumbnail(buffer.Bytes(), thumbnailDir, uuidStr, fi.Width, fi.Height); err != nil { // create a thumbnail
-		return nil, errors.Wrap(err, "UploadImageWithThumbnail()")
+	buffer = bytes.NewBuffer(prepared)
+	if imgHeader.Format == "svg" { // the uploaded SVG is never saved, not even in the ArchiveDir
+		original = prepared
+	}
+
+	for i := 0; ; i++ {
+		if animated {
+			fi, err = saveAnimation(buffer.Bytes(), imgHeader, header.Filename, uuidBase, imageDir, options) // keep or convert the animation
+		} else {
+			fi, err = saveImage(buffer.Bytes(), header.Filename, uuidStr, imageDir, options.MaxWidth, options.MaxHeight) // re-save the uploaded image
+			if err == nil {                                                                                              // the frames of the upload, also when only the first one is saved
+				fi.Frames, fi.Duration = imgHeader.Frames, imgHeader.Duration
+			}
+		}
+		if err != ErrFileExists || renamed || i == 10 {
+			break
+		}
+		uuidBase = uuid.New().String() // the UUID was taken in the meantime, never replace a file
+		uuidStr = uuidBase + ".jpg"
+	}
+	if _, ok := err.(*ImageLimitError); ok || err == ErrFileExists { // a kept animation that is too large, a name taken by another upload
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "UploadImageWithOptions()")
+	}
+	saved = append(saved, savedFile{imageDir, fi.Name})
+	if err := quotaReader.settle(fi.Size); err != nil { // the saved image counts, not the upload
+		return nil, err
+	}
+	if len(options.ArchiveDir) > 0 { // keep the untouched original
+		fi.ArchivedName = uuidBase + "." + getFileExtension(header.Filename)
+		root, err := OpenRoot(options.ArchiveDir)
+		if err != nil {
+			return nil, errors.Wrap(err, "UploadImageWithOptions()")
+		}
+		defer root.Close()
+		if err := root.writeNewFile(fi.ArchivedName, original, 0644); err == ErrFileExists {
+			return nil, err
+		} else if err != nil {
+			return nil, errors.Wrap(err, "UploadImageWithOptions()")
+		}
+		saved = append(saved, savedFile{options.ArchiveDir, fi.ArchivedName})
+	}
+	fi.ThumbnailName = uuidStr
+	queued := options.Jobs != nil && fi.IsImage // made from the saved image later, the hook is called by the job. A video is not read by the job
+	if !queued {
+		if err := saveThumbnail(buffer.Bytes(), thumbnailDir, uuidStr, imgHeader.Width, imgHeader.Height, options); err == ErrFileExists { // create a thumbnail, the first frame of animations
+			return nil, err
+		} else if err != nil {
+			return nil, errors.Wrap(err, "UploadImageWithOptions()")
+		}
+		saved = append(saved, savedFile{thumbnailDir, uuidStr})
+		DefaultHooks.afterThumbnail(event, fi)
+	}
+	fi.Sanitized = imgHeader.Format == "svg"
+
+	if !options.NoPlaceholders {
+		if err := setPlaceholders(fi, buffer.Bytes(), imgHeader.Width, imgHeader.Height); err != nil {
+			return nil, errors.Wrap(err, "UploadImageWithOptions()")
+		}
+	}
+	if err := recordOwner(fi, options.Owner); err != nil {
+		return nil, errors.Wrap(err, "UploadImageWithOptions()")
+	}
+	if queued { // the last step, a job is never left for an image that was removed again
+		params := thumbnailJobParams{thumbnailDir, uuidStr, options.ThumbnailWidth, options.ThumbnailHeight, options.Crop, options.FocalPoint}
+		if _, err := options.Jobs.Enqueue(ThumbnailJob, imageDir, fi.Name, params); err != nil {
+			return nil, errors.Wrap(err, "UploadImageWithOptions()")
+		}
+		fi.Processing = JobPending
 	}
+	setFileURLs(fi, options.SetURLs)
+	DefaultHooks.afterStore(event, fi)
 
 	return fi, nil
 }
@@ -22,13 +22,24 @@ func Test_saveThumbnail(t *testing.T) {
 	if err != nil {
 		t.Errorf("base64.NewDecoder(): Error reading base64 encoded file! [%s]", err)
 	}
-	if err := saveThumbnail(buf, dir, testName, 250, 340); err != nil {
+	if err := saveThumbnail(buf, dir, testName, 250, 340, ImageOptions{}); err != nil {
 		t.Errorf("saveThumbnail(): Returned an error! [%s]", err)
 	}
 
 	if _, err := os.Stat(dir + string(os.PathSeparator) + testName); err != nil { // check if the file exists
 		t.Errorf("saveThumbnail(): Thumbnail does not exist! [%s]", err)
 	}
+
+	// an existing file is never replaced
+	if err := ioutil.WriteFile(dir+string(os.PathSeparator)+testName, []byte("another thumbnail"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %s", err)
+	}
+	if err := saveThumbnail(buf, dir, testName, 250, 340, ImageOptions{}); err != ErrFileExists {
+		t.Errorf("saveThumbnail(): Returned[%v]. Expected[%s]", err, ErrFileExists)
+	}
+	if b, _ := ioutil.ReadFile(dir + string(os.PathSeparator) + testName); string(b) != "another thumbnail" {
+		t.Errorf("saveThumbnail(): The existing file was replaced!")
+	}
 }
 
 func Test_saveImage(t *testing.T) {
@@ -46,7 +57,7 @@ func Test_saveImage(t *testing.T) {
 		t.Errorf("base64.NewDecoder(): Error reading base64 encoded file! [%s]", err)
 	}
 
-	fi, err := saveImage(buf, "gopher.png", testName, dir)
+	fi, err := saveImage(buf, "gopher.png", testName, dir, 0, 0)
 	if err != nil {
 		t.Errorf("saveImage(): Returned an error! [%s]", err)
 	}
@@ -115,3 +126,123 @@ func Test_UploadAllImages(t *testing.T) {
 		}
 	}
 }
+
+func Test_fitBoundingBox(t *testing.T) {
+	var list = []struct {
+		width, height, maxWidth, maxHeight int
+		expWidth, expHeight                int
+	}{
+		{8000, 6000, 2560, 2560, 2560, 1920},
+		{6000, 8000, 2560, 2560, 1920, 2560},
+		{1000, 500, 2560, 2560, 1000, 500}, // never upscale
+		{4000, 1000, 2000, 0, 2000, 500},
+		{4000, 1000, 0, 250, 1000, 250},
+		{4000, 1000, 0, 0, 4000, 1000},
+		{10000, 1, 100, 100, 100, 1}, // at least one pixel
+	}
+	for _, l := range list {
+		if w, h := fitBoundingBox(l.width, l.height, l.maxWidth, l.maxHeight); w != l.expWidth || h != l.expHeight {
+			t.Errorf("fitBoundingBox(%d, %d, %d, %d): Returned[%dx%d]. Expected: %dx%d", l.width, l.height, l.maxWidth, l.maxHeight, w, h, l.expWidth, l.expHeight)
+		}
+	}
+}
+
+func Test_UploadImageWithThumbnail_downscale(t *testing.T) {
+	tempDir1, tempDir2, tempDir3 := "testing-filevalidator-images", "testing-filevalidator-thumbnails", "testing-filevalidator-originals"
+	dir1, err := ioutil.TempDir("", tempDir1) // make three temporary directories
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir2, err := ioutil.TempDir("", tempDir2)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir3, err := ioutil.TempDir("", tempDir3)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir1) // delete the temp directories
+	defer os.RemoveAll(dir2)
+	defer os.RemoveAll(dir3)
+
+	defaultOptions := DefaultImageOptions
+	defer func() { DefaultImageOptions = defaultOptions }()
+	DefaultImageOptions = ImageOptions{MaxWidth: 100, MaxHeight: 100, ArchiveDir: dir3}
+
+	req := setupRequestMultipartForm(&testFile{gopherPNG, "imageupload", "gopher.png"})
+
+	fi, err := UploadImageWithThumbnail(req.MultipartForm.File["imageupload"][0], dir1, dir2)
+	if err != nil {
+		t.Fatalf("UploadImageWithThumbnail(): Returned an error! [%s]", err)
+	}
+	if fi.Width != 74 || fi.Height != 100 { // gopher.png is 250x340
+		t.Errorf("UploadImageWithThumbnail(): Image was not scaled down! [%dx%d]", fi.Width, fi.Height)
+	}
+
+	archived, err := os.Stat(dir3 + string(os.PathSeparator) + fi.ArchivedName)
+	if err != nil { // check if the original exists
+		t.Fatalf("UploadImageWithThumbnail(): Original does not exist! [%s]", err)
+	}
+	if archived.Size() != 17668 {
+		t.Errorf("UploadImageWithThumbnail(): Original was modified! Size[%d]", archived.Size())
+	}
+}
+
+func Test_UploadImageWithOptions_cleanup(t *testing.T) {
+	tempDir1, tempDir2, tempDir3 := "testing-filevalidator-images", "testing-filevalidator-thumbnails", "testing-filevalidator-originals"
+	dir1, err := ioutil.TempDir("", tempDir1) // make three temporary directories
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir2, err := ioutil.TempDir("", tempDir2)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir3, err := ioutil.TempDir("", tempDir3)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir1) // delete the temp directories
+	defer os.RemoveAll(dir2)
+	defer os.RemoveAll(dir3)
+
+	quota, err := NewFileQuota(dir3+string(os.PathSeparator)+"usage.json", 1<<20)
+	if err != nil {
+		t.Fatalf("NewFileQuota(): Returned an error! [%s]", err)
+	}
+	DefaultQuota = quota
+	defer func() { DefaultQuota = nil }()
+
+	// the job can not be saved once the image and the original are written
+	jobsDir, err := ioutil.TempDir("", "testing-filevalidator-jobs")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	jobs, err := NewJobQueue(jobsDir)
+	if err != nil {
+		t.Fatalf("NewJobQueue(): Returned an error! [%s]", err)
+	}
+	os.RemoveAll(jobsDir)
+
+	req := setupRequestMultipartForm(&testFile{gopherPNG, "imageupload", "gopher.png"})
+	if _, err := UploadImageWithOptions(req.MultipartForm.File["imageupload"][0], dir1, dir2, ImageOptions{ArchiveDir: dir3, Owner: "alice", Jobs: jobs}); err == nil {
+		t.Fatalf("UploadImageWithOptions(): Should return an error when the job can not be saved!")
+	}
+
+	for _, dir := range []string{dir1, dir2} {
+		for _, fi := range uploadDirEntries(t, dir) {
+			if fi.Name() != stateDirName {
+				t.Errorf("UploadImageWithOptions(): Left %s in %s", fi.Name(), dir)
+			}
+		}
+	}
+	if records, _ := ioutil.ReadDir(dir1 + string(os.PathSeparator) + stateDirName); len(records) > 0 { // the job is saved after the owner
+		t.Errorf("UploadImageWithOptions(): Left the record of the owner [%d files]", len(records))
+	}
+	if files := uploadDirEntries(t, dir3); len(files) != 1 { // only the usage file
+		t.Errorf("UploadImageWithOptions(): Left the archived original [%d files]", len(files))
+	}
+	if usage := quota.Usage("alice"); usage != 0 {
+		t.Errorf("UploadImageWithOptions(): The quota still counts %d bytes", usage)
+	}
+}
@@ -6,52 +6,134 @@ import (
 	"github.com/pkg/errors" // external dependency
 )
 
+// files opened at the same time for reading their mimetypes
+const mimeTypeWorkers = 8
+
 /*
 	Loop through a directory and return a slice of structs with information about all the files in said directory
 	includeMimeType - flag, whether or not to include each file's mimetype, the operation takes more processing of the files
+	states - only return files in one of these states, see Quarantine. Files uploaded without a Quarantine are approved
+	A file that can not be read is returned with Error set instead of failing the whole listing
+*/
+func GetDirectoryContentsData(directory string, includeMimeType bool, states ...UploadState) ([]FileInfo, error) {
+	return GetDirectoryContentsWithOptions(directory, DirectoryOptions{IncludeMimeType: includeMimeType, States: states})
+}
+
+/*
+	Options for GetDirectoryContentsWithOptions()
+
+	IncludeMimeType - read the first bytes of each file for its mimetype
+	States - only files in one of these states, see Quarantine. Empty is all files. A file whose record can not be read is listed with the Error
+	Details - image sizes, times, thumbnails and URLs, see ListingDetails
 */
-func GetDirectoryContentsData(directory string, includeMimeType bool) ([]FileInfo, error) {
+type DirectoryOptions struct {
+	IncludeMimeType bool
+	States          []UploadState
+	Details         ListingDetails
+}
+
+// same as GetDirectoryContentsData(), see DirectoryOptions
+func GetDirectoryContentsWithOptions(directory string, options DirectoryOptions) ([]FileInfo, error) {
 	var fis []FileInfo
 
-	if _, err := os.Stat(directory); os.IsNotExist(err) { // check if the directory exists
-		return nil, ErrDirectoryDoesNotExist
+	root, err := OpenRoot(directory) // check if the directory exists
+	if err != nil {
+		return nil, err
 	}
+	defer root.Close()
 
-	var file *os.File
-	var err error
-
-	if file, err = os.Open(directory); err != nil { // open the directory
+	fileSlice, err := readRootDir(root)
+	if err != nil {
 		return nil, errors.Wrap(err, "GetDirectoryContentsData()")
 	}
 
-	if includeMimeType { // will have to open each file to examine first bytes
-		fileSlice, _ := file.Readdir(0)
-		for _, fi := range fileSlice {
-			if fi.IsDir() == false {
-				file, err := os.Open(directory + string(os.PathSeparator) + fi.Name())
-				if err != nil { // check for error
-					return nil, errors.Wrap(err, "GetDirectoryContentsData()")
-				}
-				defer file.Close()
-				mimetype, err := getMimeType(file)
-				if err != nil { // check for error
-					return nil, errors.Wrap(err, "GetDirectoryContentsData()")
-				}
-
-				fis = append(fis, FileInfo{Name: fi.Name(), Size: fi.Size(), Directory: directory, MimeType: mimetype, IsImage: isFileImage(mimetype)})
+	var files []listedFile
+	for _, fi := range fileSlice {
+		if fi.IsDir() == false {
+			state, err := fileState(root, ".", fi.Name())
+			if err == nil && len(options.States) > 0 && !inStates(options.States, state) {
+				continue
+			}
+
+			file := FileInfo{Name: fi.Name(), Size: fi.Size(), Directory: directory, State: state}
+			if err != nil { // an unreadable record, the file is listed with the error
+				file.Error = errors.Cause(err).Error()
 			}
+			fis = append(fis, file)
+			files = append(files, listedFile{fi.Name(), fi})
 		}
+	}
 
-		return fis, nil
+	if options.IncludeMimeType { // will have to open each file to examine first bytes
+		sniffMimeTypes(root, files, fis)
+	}
+	if err := options.Details.fill(root, files, fis); err != nil {
+		return nil, err
 	}
 
-	// includeMimeType flag is false
-	fileSlice, _ := file.Readdir(0)
-	for _, fi := range fileSlice {
-		if fi.IsDir() == false {
-			fis = append(fis, FileInfo{Name: fi.Name(), Size: fi.Size(), Directory: directory})
+	return fis, nil
+}
+
+// set MimeType and IsImage of each file, see inParallel(). Errors are set on the file
+func sniffMimeTypes(root *Root, files []listedFile, fis []FileInfo) {
+	inParallel(len(fis), func(i int) {
+		mimetype, err := rootMimeType(root, files[i].name)
+		if err != nil {
+			fis[i].Error = errors.Cause(err).Error()
+			return
 		}
+		fis[i].MimeType, fis[i].IsImage = mimetype, isFileImage(mimetype)
+	})
+}
+
+// the mimetype of a file in a Root, the file is closed before returning
+func rootMimeType(root *Root, name string) (string, error) {
+	file, err := root.Open(name)
+	if err != nil {
+		return "", err
 	}
+	defer file.Close()
+	return getMimeType(file)
+}
 
-	return fis, nil
+/*
+	The entries of a directory, symbolic links are replaced by the file they point to
+	Links that point outside of the directory or to nothing are left out
+*/
+func readRootDir(root *Root) ([]os.FileInfo, error) {
+	entries, err := root.Readdir()
+	if err != nil {
+		return nil, err
+	}
+
+	fileSlice := entries[:0]
+	for _, fi := range entries {
+		if fi, ok := resolveEntry(root, fi); ok {
+			fileSlice = append(fileSlice, fi)
+		}
+	}
+
+	return fileSlice, nil
+}
+
+// replace a symbolic link by the file it points to, false for links outside of the directory or to nothing
+func resolveEntry(root *Root, fi os.FileInfo) (os.FileInfo, bool) {
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return fi, true
+	}
+	target, err := root.Stat(fi.Name())
+	if err != nil {
+		return nil, false
+	}
+	return renamedFileInfo{target, fi.Name()}, true
+}
+
+// the os.FileInfo of a link target, with the name of the link
+type renamedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi renamedFileInfo) Name() string {
+	return fi.name
 }
@@ -1,6 +1,9 @@
 package fileupload
 
 import (
+	"encoding/base64"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -83,3 +86,176 @@ func Test_GetDirectoryContentsData(t *testing.T) {
 		t.Errorf("GetDirectoryContentsData(): Does not match prepared testing list! Size variable: [%d]", foundResultsSize)
 	}
 }
+
+func Test_GetDirectoryContentsData_openFiles(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("no /proc/self/fd")
+	}
+	tempDir := "testing-filevalidator"
+	dir, err := ioutil.TempDir("", tempDir) // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	for i := 0; i < 300; i++ {
+		if err := ioutil.WriteFile(fmt.Sprintf("%s%cfile-%03d.txt", dir, os.PathSeparator, i), []byte("some text"), 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile: %s", err)
+		}
+	}
+
+	before, _ := ioutil.ReadDir("/proc/self/fd")
+	fis, err := GetDirectoryContentsData(dir, true)
+	if err != nil {
+		t.Fatalf("GetDirectoryContentsData(): Returned an error! [%s]", err)
+	}
+	after, _ := ioutil.ReadDir("/proc/self/fd")
+	if len(after) > len(before) {
+		t.Errorf("GetDirectoryContentsData(): [%d] files were left open", len(after)-len(before))
+	}
+
+	if len(fis) != 300 {
+		t.Fatalf("GetDirectoryContentsData(): Returned[%d] files. Expected 300", len(fis))
+	}
+	for i, fi := range fis {
+		if fi.Name != fmt.Sprintf("file-%03d.txt", i) || len(fi.MimeType) == 0 || len(fi.Error) > 0 {
+			t.Errorf("GetDirectoryContentsData(): Wrong file at [%d] [%+v]", i, fi)
+			break
+		}
+	}
+}
+
+func Test_GetDirectoryContentsWithOptions(t *testing.T) {
+	tempDir1, tempDir2 := "testing-filevalidator-images", "testing-filevalidator-thumbnails"
+	dir1, err := ioutil.TempDir("", tempDir1) // make two temporary directories
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir2, err := ioutil.TempDir("", tempDir2)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir1) // delete the temp directories
+	defer os.RemoveAll(dir2)
+
+	png, _ := base64.StdEncoding.DecodeString(gopherPNG)
+	files := map[string][]byte{
+		dir1 + "/gopher.png": png,
+		dir1 + "/notes.txt":  []byte("not an image"),
+		dir2 + "/gopher.jpg": []byte("a thumbnail"),
+	}
+	for path, data := range files {
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile: %s", err)
+		}
+	}
+
+	builder := NewURLBuilder()
+	builder.ThumbnailURL = "/thumbnails/{name}"
+	details := ListingDetails{ImageSize: true, Times: true, ThumbnailDir: dir2, URLBuilder: builder}
+	fis, err := GetDirectoryContentsWithOptions(dir1, DirectoryOptions{Details: details})
+	if err != nil {
+		t.Fatalf("GetDirectoryContentsWithOptions(): Returned an error! [%s]", err)
+	}
+	if len(fis) != 2 {
+		t.Fatalf("GetDirectoryContentsWithOptions(): Returned[%d] files. Expected 2", len(fis))
+	}
+	for _, fi := range fis {
+		if fi.ModTime == nil || fi.ModTime.IsZero() || len(fi.Error) > 0 {
+			t.Errorf("GetDirectoryContentsWithOptions(): No modification time [%+v]", fi)
+		}
+		switch fi.Name {
+		case "gopher.png":
+			if fi.Width != 250 || fi.Height != 340 || !fi.IsImage || fi.MimeType != "image/png" || fi.ThumbnailName != "gopher.jpg" || fi.ThumbnailUrl != "/thumbnails/gopher.jpg" {
+				t.Errorf("GetDirectoryContentsWithOptions(): Wrong image details [%+v]", fi)
+			}
+		case "notes.txt":
+			if fi.Width != 0 || fi.IsImage || len(fi.ThumbnailUrl) > 0 {
+				t.Errorf("GetDirectoryContentsWithOptions(): A text file has image details [%+v]", fi)
+			}
+		}
+	}
+
+	if _, err := GetDirectoryContentsWithOptions(dir1, DirectoryOptions{Details: ListingDetails{ThumbnailDir: dir2 + "/missing"}}); err != ErrDirectoryDoesNotExist {
+		t.Errorf("GetDirectoryContentsWithOptions(): Should return ErrDirectoryDoesNotExist! [%v]", err)
+	}
+}
+
+// a Scanner that calls a function, for looking at an upload while it is copied
+type scanFunc func(r io.Reader) (ScanResult, error)
+
+func (f scanFunc) Scan(r io.Reader) (ScanResult, error) {
+	return f(r)
+}
+
+func Test_GetDirectoryContentsData_uploading(t *testing.T) {
+	tempDir := "testing-filevalidator"
+	dir, err := ioutil.TempDir("", tempDir) // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	// the upload is written but not yet renamed while it is scanned
+	var listed, walked []FileInfo
+	DefaultScanner = scanFunc(func(r io.Reader) (ScanResult, error) {
+		listed, _ = GetDirectoryContentsData(dir, true)
+		walked, _ = WalkDirectory(dir, WalkOptions{})
+		return ScanResult{}, nil
+	})
+	defer func() { DefaultScanner = nil }()
+
+	req := setupRequestMultipartForm(&testFile{carTARGZ, "fileupload", "car.tar.gz"})
+	if _, err := UploadFile(req.MultipartForm.File["fileupload"][0], dir, true); err != nil {
+		t.Fatalf("UploadFile(): Returned an error! [%s]", err)
+	}
+	if len(listed) > 0 || len(walked) > 0 {
+		t.Errorf("GetDirectoryContentsData(): A partial upload was listed! [%+v %+v]", listed, walked)
+	}
+}
+
+func Test_GetDirectoryContentsData_corruptRecord(t *testing.T) {
+	tempDir := "testing-filevalidator"
+	dir, err := ioutil.TempDir("", tempDir) // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	req := setupRequestMultipartForm(&testFile{carTARGZ, "fileupload", "car.tar.gz"}, &testFile{blueJPG, "fileupload", "blue.jpg"})
+	fis, err := UploadAllFiles(req.MultipartForm.File, dir, true)
+	if err != nil {
+		t.Fatalf("UploadAllFiles(): Returned an error! [%s]", err)
+	}
+	if err := os.Mkdir(dir+string(os.PathSeparator)+stateDirName, 0755); err != nil {
+		t.Fatalf("os.Mkdir: %s", err)
+	}
+	if err := ioutil.WriteFile(dir+string(os.PathSeparator)+stateDirName+string(os.PathSeparator)+fis[0].Name+".json", []byte("{not json"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %s", err)
+	}
+
+	// the other files are still listed, the file with the broken record gets the error
+	listed, err := GetDirectoryContentsData(dir, false, StateApproved)
+	if err != nil {
+		t.Fatalf("GetDirectoryContentsData(): Returned an error! [%s]", err)
+	}
+	walked, err := WalkDirectory(dir, WalkOptions{States: []UploadState{StateApproved}})
+	if err != nil {
+		t.Fatalf("WalkDirectory(): Returned an error! [%s]", err)
+	}
+	page, err := ListDirectory(dir, ListOptions{})
+	if err != nil {
+		t.Fatalf("ListDirectory(): Returned an error! [%s]", err)
+	}
+	for _, files := range [][]FileInfo{listed, walked, page.Files} {
+		if len(files) != 2 {
+			t.Errorf("GetDirectoryContentsData(): Returned[%+v]. Expected 2 files", files)
+			continue
+		}
+		for _, fi := range files {
+			if (fi.Name == fis[0].Name) != (len(fi.Error) > 0) {
+				t.Errorf("GetDirectoryContentsData(%s): Wrong error! [%s]", fi.Name, fi.Error)
+			}
+		}
+	}
+}
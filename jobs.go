@@ -0,0 +1,506 @@
+package fileupload
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid" // external dependencies
+	"github.com/pkg/errors"
+)
+
+var ErrJobNotFound = errors.New("No job was found for this file!")
+var ErrUnknownJobKind = errors.New("No handler is registered for this kind of job!")
+
+type JobStatus string
+
+const (
+	JobPending JobStatus = "pending" // waiting for a worker, also between retries
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed" // every attempt failed
+)
+
+// made by NewJobQueue(), for ImageOptions.Jobs
+const ThumbnailJob = "thumbnail"
+
+// background work on a saved file, see JobQueue
+type Job struct {
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"` // the JobHandler that runs it
+	Directory string          `json:"directory"`
+	Name      string          `json:"name"` // the file, see JobQueue.Status()
+	Params    json.RawMessage `json:"params,omitempty"`
+	Status    JobStatus       `json:"status"`
+	Attempts  int             `json:"attempts"`
+	Error     string          `json:"error,omitempty"` // of the last attempt
+	NextRun   time.Time       `json:"nextRun"`
+	Updated   time.Time       `json:"updated"`
+}
+
+// does the work of a job, an error retries it later
+type JobHandler func(job *Job) error
+
+/*
+	Runs jobs on a few goroutines, each job is saved to a file in Dir so it survives a restart
+	Failed jobs are retried after Backoff, doubled after each attempt up to MaxBackoff, until MaxAttempts
+	Finished jobs are kept so their status can be polled, see Cleanup()
+	ErrorLog - jobs that could not be saved by a worker, the standard logger when nil
+	Example:
+	jobs, err := fileupload.NewJobQueue("/var/lib/app/jobs")
+	jobs.Handle("poster", makeVideoPoster)
+	jobs.Start()
+	defer jobs.Stop()
+	fi, err := fileupload.UploadImageWithOptions(header, "images", "thumbnails", fileupload.ImageOptions{Jobs: jobs})
+*/
+type JobQueue struct {
+	Dir         string
+	Workers     int
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	ErrorLog    *log.Logger
+
+	mu       sync.Mutex
+	handlers map[string]JobHandler
+	jobs     map[string]*Job // by ID
+	wake     chan struct{}
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// a queue with 2 workers that tries each job 5 times, the directory has to exist
+func NewJobQueue(dir string) (*JobQueue, error) {
+	root, err := OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	root.Close()
+
+	q := &JobQueue{Dir: dir, Workers: 2, MaxAttempts: 5, Backoff: time.Second, MaxBackoff: 5 * time.Minute, handlers: map[string]JobHandler{}, jobs: map[string]*Job{}}
+	q.Handle(ThumbnailJob, runThumbnailJob)
+	return q, nil
+}
+
+// the handler of a kind of job, set before Start()
+func (q *JobQueue) Handle(kind string, handler JobHandler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[kind] = handler
+}
+
+/*
+	Load the saved jobs and start the workers
+	Jobs that were running when the program stopped are run again, handlers have to cope with work that was partly done
+*/
+func (q *JobQueue) Start() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stop != nil {
+		return nil // already started
+	}
+
+	paths, err := filepath.Glob(filepath.Join(q.Dir, "*.json"))
+	if err != nil {
+		return errors.Wrap(err, "JobQueue.Start()")
+	}
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrap(err, "JobQueue.Start()")
+		}
+		job := &Job{}
+		if err := json.Unmarshal(b, job); err != nil {
+			return errors.Wrapf(err, "JobQueue.Start() Filename[%s]", path)
+		}
+		if job.Status == JobRunning {
+			job.Status = JobPending
+		}
+		q.jobs[job.ID] = job
+	}
+
+	workers := q.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	q.wake, q.stop = make(chan struct{}, 1), make(chan struct{})
+	for w := 0; w < workers; w++ {
+		q.wg.Add(1)
+		go q.work(q.stop, q.wake)
+	}
+	return nil
+}
+
+// stop the workers, running jobs are finished first. Pending jobs stay saved for the next Start()
+func (q *JobQueue) Stop() {
+	q.mu.Lock()
+	stop := q.stop
+	q.stop = nil
+	q.mu.Unlock()
+	if stop == nil {
+		return
+	}
+
+	close(stop)
+	q.wg.Wait()
+}
+
+// save a job for a file and wake a worker, params are saved as JSON and given to the handler in Job.Params
+func (q *JobQueue) Enqueue(kind, directory, name string, params interface{}) (*Job, error) {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return nil, errors.Wrap(err, "JobQueue.Enqueue()")
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.handlers[kind]; !ok {
+		return nil, ErrUnknownJobKind
+	}
+
+	job := &Job{ID: uuid.New().String(), Kind: kind, Directory: directory, Name: name, Params: b, Status: JobPending, NextRun: time.Now().UTC()}
+	if err := q.save(job); err != nil {
+		return nil, errors.Wrap(err, "JobQueue.Enqueue()")
+	}
+	q.jobs[job.ID] = job
+	q.signal()
+
+	copied := *job
+	return &copied, nil
+}
+
+// the jobs of a file, oldest first. Returns ErrJobNotFound when there are none
+func (q *JobQueue) Jobs(name string) ([]Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var jobs []Job
+	for _, job := range q.jobs {
+		if job.Name == name {
+			jobs = append(jobs, *job)
+		}
+	}
+	if len(jobs) == 0 {
+		return nil, ErrJobNotFound
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].NextRun.Before(jobs[j].NextRun) })
+	return jobs, nil
+}
+
+/*
+	The status of all the jobs of a file together, for polling
+	JobFailed if one of them failed, JobRunning or JobPending while one is not finished, otherwise JobDone
+*/
+func (q *JobQueue) Status(name string) (JobStatus, error) {
+	jobs, err := q.Jobs(name)
+	if err != nil {
+		return "", err
+	}
+
+	status := JobDone
+	for _, job := range jobs {
+		switch {
+		case job.Status == JobFailed:
+			return JobFailed, nil
+		case job.Status == JobRunning:
+			status = JobRunning
+		case job.Status == JobPending && status == JobDone:
+			status = JobPending
+		}
+	}
+	return status, nil
+}
+
+/*
+	Remove the jobs of a file, called when the file is deleted. No error when the file has none
+	A running job is not stopped, its result is not saved
+*/
+func (q *JobQueue) Cancel(name string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for id, job := range q.jobs {
+		if job.Name != name {
+			continue
+		}
+		if err := os.Remove(q.jobPath(id)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "JobQueue.Cancel()")
+		}
+		delete(q.jobs, id)
+	}
+	return nil
+}
+
+// remove the finished jobs last changed before a time
+func (q *JobQueue) Cleanup(before time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for id, job := range q.jobs {
+		if (job.Status == JobDone || job.Status == JobFailed) && job.Updated.Before(before) {
+			if err := os.Remove(q.jobPath(id)); err != nil && !os.IsNotExist(err) {
+				return errors.Wrap(err, "JobQueue.Cleanup()")
+			}
+			delete(q.jobs, id)
+		}
+	}
+	return nil
+}
+
+/*
+	Answers "GET ?file=name" with the status of the jobs of a file, in the JSON format of the upload functions
+	{"files": [{"name": "uuid.jpg", "processing": "pending"}]}
+*/
+func (q *JobQueue) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := r.URL.Query().Get("file")
+		status, err := q.Status(name)
+		if err == ErrJobNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		b, err := json.Marshal(map[string][]FileInfo{"files": {{Name: name, Processing: status}}})
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(b)
+	})
+}
+
+// run due jobs until Stop(), sleep until the next one is due or a job is added
+func (q *JobQueue) work(stop, wake chan struct{}) {
+	defer q.wg.Done()
+
+	for {
+		job, handler, wait := q.next()
+		if job == nil {
+			timer := time.NewTimer(wait)
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-wake:
+			case <-timer.C:
+			}
+			timer.Stop()
+			continue
+		}
+
+		err := handler(job)
+		q.finish(job, err)
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+// the first due job, marked running. Without one, how long to wait for the next
+func (q *JobQueue) next() (*Job, JobHandler, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	var due *Job
+	wait := time.Minute
+	for _, job := range q.jobs {
+		if job.Status != JobPending {
+			continue
+		}
+		if until := job.NextRun.Sub(now); until > 0 {
+			if until < wait {
+				wait = until
+			}
+			continue
+		}
+		if due == nil || job.NextRun.Before(due.NextRun) {
+			due = job
+		}
+	}
+	if due == nil {
+		return nil, nil, wait
+	}
+
+	due.Status = JobRunning
+	due.Attempts++
+	if err := q.save(due); err != nil { // only run attempts that are saved, MaxAttempts holds across restarts
+		q.logf("JobQueue: Job[%s] could not be saved, it is tried again later [%s]", due.ID, err)
+		due.Status, due.Attempts = JobPending, due.Attempts-1
+		delay := q.backoff(due.Attempts + 1)
+		due.NextRun = now.UTC().Add(delay)
+		return nil, nil, delay
+	}
+
+	copied := *due
+	return &copied, q.handlers[due.Kind], 0
+}
+
+// save the result of an attempt, a failed job is retried later
+func (q *JobQueue) finish(result *Job, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[result.ID]
+	if !ok {
+		return
+	}
+	job.Error = ""
+	switch {
+	case err == nil:
+		job.Status = JobDone
+	case job.Attempts >= q.MaxAttempts:
+		job.Status, job.Error = JobFailed, err.Error()
+	default:
+		job.Status, job.Error = JobPending, err.Error()
+		job.NextRun = time.Now().UTC().Add(q.backoff(job.Attempts))
+	}
+	if err := q.save(job); err != nil { // the saved job is still running, it is run again after a restart
+		q.logf("JobQueue: The result of Job[%s] could not be saved [%s]", job.ID, err)
+	}
+	q.signal() // the other workers may be waiting for this one
+}
+
+// the wait before retrying after a number of attempts
+func (q *JobQueue) backoff(attempts int) time.Duration {
+	delay := q.Backoff
+	for i := 1; i < attempts && delay < q.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if q.MaxBackoff > 0 && delay > q.MaxBackoff {
+		delay = q.MaxBackoff
+	}
+	return delay
+}
+
+// errors of the workers, they have no caller to return them to
+func (q *JobQueue) logf(format string, args ...interface{}) {
+	if q.ErrorLog != nil {
+		q.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// wake one waiting worker, never blocks
+func (q *JobQueue) signal() {
+	if q.wake == nil {
+		return
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *JobQueue) jobPath(id string) string {
+	return filepath.Join(q.Dir, id+".json")
+}
+
+// write a job to its file, the file is replaced at once so a crash never leaves half a job
+func (q *JobQueue) save(job *Job) error {
+	job.Updated = time.Now().UTC()
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(q.Dir, ".job-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), q.jobPath(job.ID))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// the Params of a ThumbnailJob
+type thumbnailJobParams struct {
+	ThumbnailDir    string     `json:"thumbnailDir"`
+	ThumbnailName   string     `json:"thumbnailName"`
+	ThumbnailWidth  int        `json:"width,omitempty"`
+	ThumbnailHeight int        `json:"height,omitempty"`
+	Crop            CropMode   `json:"crop,omitempty"`
+	FocalPoint      FocalPoint `json:"focalPoint"`
+}
+
+// make the thumbnail of a saved image, see ImageOptions.Jobs
+func runThumbnailJob(job *Job) error {
+	params := thumbnailJobParams{}
+	if err := json.Unmarshal(job.Params, &params); err != nil {
+		return errors.Wrap(err, "runThumbnailJob()")
+	}
+
+	root, err := OpenRoot(job.Directory)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	file, err := root.Open(job.Name)
+	if err != nil {
+		return errors.Wrap(err, "runThumbnailJob()")
+	}
+	defer file.Close()
+	h, err := readImageHeader(file, 1)
+	if err != nil {
+		return errors.Wrap(err, "runThumbnailJob()")
+	}
+	buffer, err := ioutil.ReadAll(file)
+	if err != nil {
+		return errors.Wrap(err, "runThumbnailJob()")
+	}
+
+	options := ImageOptions{ThumbnailWidth: params.ThumbnailWidth, ThumbnailHeight: params.ThumbnailHeight, Crop: params.Crop, FocalPoint: params.FocalPoint}
+	thumbnail, err := makeThumbnail(buffer, h.Width, h.Height, options)
+	if err != nil {
+		return err
+	}
+	thumbnailRoot, err := OpenRoot(params.ThumbnailDir)
+	if err != nil {
+		return err
+	}
+	defer thumbnailRoot.Close()
+	if err := thumbnailRoot.writeNewFile(params.ThumbnailName, thumbnail, 0644); err == ErrFileExists {
+		existing, readErr := thumbnailRoot.ReadFile(params.ThumbnailName)
+		if readErr != nil || !bytes.Equal(existing, thumbnail) { // the file of another upload is never replaced
+			return err
+		}
+		// the same thumbnail, saved by an earlier attempt that was not finished
+	} else if err != nil {
+		return errors.Wrap(err, "runThumbnailJob()")
+	}
+
+	fi := &FileInfo{Name: job.Name, Directory: job.Directory, IsImage: true, Width: h.Width, Height: h.Height, ThumbnailName: params.ThumbnailName}
+	DefaultHooks.afterThumbnail(&UploadEvent{Directory: job.Directory, Name: job.Name}, fi)
+	return nil
+}
@@ -0,0 +1,276 @@
+package fileupload
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors" // external dependency
+)
+
+// wait until the jobs of a file are finished
+func waitForJobs(t *testing.T, q *JobQueue, name string) JobStatus {
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if status, err := q.Status(name); err == nil && (status == JobDone || status == JobFailed) {
+			return status
+		}
+	}
+	t.Fatalf("JobQueue: The jobs of [%s] did not finish", name)
+	return ""
+}
+
+func TestJobQueue(t *testing.T) {
+	tempDir := "testing-filevalidator-jobs"
+	dir, err := ioutil.TempDir("", tempDir) // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	q, err := NewJobQueue(dir)
+	if err != nil {
+		t.Fatalf("NewJobQueue(): Returned an error! [%s]", err)
+	}
+	q.Backoff, q.MaxAttempts = 10*time.Millisecond, 3
+
+	var mu sync.Mutex
+	calls := map[string]int{}
+	q.Handle("flaky", func(job *Job) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls[job.Name]++
+		if calls[job.Name] < 3 {
+			return errors.New("try again")
+		}
+		return nil
+	})
+	q.Handle("broken", func(job *Job) error { return errors.New("always broken") })
+
+	if _, err := q.Enqueue("missing", dir, "a.jpg", nil); err != ErrUnknownJobKind {
+		t.Errorf("JobQueue.Enqueue(): Should return ErrUnknownJobKind! [%v]", err)
+	}
+	if _, err := q.Enqueue("flaky", dir, "a.jpg", map[string]int{"size": 1}); err != nil {
+		t.Fatalf("JobQueue.Enqueue(): Returned an error! [%s]", err)
+	}
+	if _, err := q.Enqueue("broken", dir, "b.jpg", nil); err != nil {
+		t.Fatalf("JobQueue.Enqueue(): Returned an error! [%s]", err)
+	}
+	if status, err := q.Status("a.jpg"); err != nil || status != JobPending {
+		t.Errorf("JobQueue.Status(): Returned[%s %v]. Expected pending before Start()", status, err)
+	}
+
+	if err := q.Start(); err != nil {
+		t.Fatalf("JobQueue.Start(): Returned an error! [%s]", err)
+	}
+	if status := waitForJobs(t, q, "a.jpg"); status != JobDone {
+		t.Errorf("JobQueue: A job that works on the third attempt returned[%s]", status)
+	}
+	if status := waitForJobs(t, q, "b.jpg"); status != JobFailed {
+		t.Errorf("JobQueue: A broken job returned[%s]", status)
+	}
+	jobs, _ := q.Jobs("b.jpg")
+	if len(jobs) != 1 || jobs[0].Attempts != 3 || jobs[0].Error != "always broken" {
+		t.Errorf("JobQueue.Jobs(): Wrong job [%+v]", jobs)
+	}
+
+	// the jobs are saved, a new queue finds them
+	q.Stop()
+	q, _ = NewJobQueue(dir)
+	q.Handle("flaky", func(job *Job) error { return nil })
+	if err := q.Start(); err != nil {
+		t.Fatalf("JobQueue.Start(): Returned an error! [%s]", err)
+	}
+	defer q.Stop()
+	if status, err := q.Status("a.jpg"); err != nil || status != JobDone {
+		t.Errorf("JobQueue.Status(): The saved job returned[%s %v]", status, err)
+	}
+
+	handler := q.StatusHandler()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/jobs?file=a.jpg", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"processing":"done"`) {
+		t.Errorf("JobQueue.StatusHandler(): Returned[%d %s]", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/jobs?file=c.jpg", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("JobQueue.StatusHandler(): An unknown file returned[%d]. Expected 404", w.Code)
+	}
+
+	if err := q.Cleanup(time.Now().Add(time.Second)); err != nil {
+		t.Errorf("JobQueue.Cleanup(): Returned an error! [%s]", err)
+	}
+	if _, err := q.Status("a.jpg"); err != ErrJobNotFound {
+		t.Errorf("JobQueue.Cleanup(): A finished job was kept! [%v]", err)
+	}
+}
+
+func TestUploadImageWithOptions_jobs(t *testing.T) {
+	tempDir1, tempDir2, tempDir3 := "testing-filevalidator-images", "testing-filevalidator-thumbnails", "testing-filevalidator-jobs"
+	dir1, err := ioutil.TempDir("", tempDir1) // make three temporary directories
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir2, err := ioutil.TempDir("", tempDir2)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir3, err := ioutil.TempDir("", tempDir3)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir1) // delete the temp directories
+	defer os.RemoveAll(dir2)
+	defer os.RemoveAll(dir3)
+
+	q, err := NewJobQueue(dir3)
+	if err != nil {
+		t.Fatalf("NewJobQueue(): Returned an error! [%s]", err)
+	}
+
+	req := setupRequestMultipartForm(&testFile{gopherPNG, "imageupload", "gopher.png"})
+	fi, err := UploadImageWithOptions(req.MultipartForm.File["imageupload"][0], dir1, dir2, ImageOptions{Jobs: q})
+	if err != nil {
+		t.Fatalf("UploadImageWithOptions(): Returned an error! [%s]", err)
+	}
+	if fi.Processing != JobPending {
+		t.Errorf("UploadImageWithOptions(): Processing is [%s]. Expected pending", fi.Processing)
+	}
+	if _, err := os.Stat(dir2 + string(os.PathSeparator) + fi.ThumbnailName); !os.IsNotExist(err) {
+		t.Errorf("UploadImageWithOptions(): The thumbnail was made before the job ran! [%v]", err)
+	}
+
+	// deleting an image removes its pending job
+	req = setupRequestMultipartForm(&testFile{blueJPG, "imageupload", "blue.jpg"})
+	deleted, err := UploadImageWithOptions(req.MultipartForm.File["imageupload"][0], dir1, dir2, ImageOptions{Jobs: q})
+	if err != nil {
+		t.Fatalf("UploadImageWithOptions(): Returned an error! [%s]", err)
+	}
+	if err := DeleteImageWithOptions(dir1, dir2, deleted.Name, ImageOptions{Jobs: q}); err != nil {
+		t.Fatalf("DeleteImageWithOptions(): Returned an error! [%s]", err)
+	}
+	if _, err := q.Jobs(deleted.Name); err != ErrJobNotFound {
+		t.Errorf("DeleteImageWithOptions(): The job was not removed! [%v]", err)
+	}
+	if files, err := ioutil.ReadDir(dir3); err != nil || len(files) != 1 { // the job of the first image
+		t.Errorf("DeleteImageWithOptions(): The saved job was not removed! [%d %v]", len(files), err)
+	}
+
+	if err := q.Start(); err != nil {
+		t.Fatalf("JobQueue.Start(): Returned an error! [%s]", err)
+	}
+	defer q.Stop()
+	if status := waitForJobs(t, q, fi.Name); status != JobDone {
+		jobs, _ := q.Jobs(fi.Name)
+		t.Fatalf("JobQueue: The thumbnail job returned[%s] %+v", status, jobs)
+	}
+	if _, err := os.Stat(dir2 + string(os.PathSeparator) + fi.ThumbnailName); err != nil {
+		t.Errorf("JobQueue: Thumbnail does not exist! [%s]", err)
+	}
+
+	// a video can not be read by the job, its thumbnail is made from the first frame right away. A script stands in for ffmpeg
+	ffmpeg := dir3 + string(os.PathSeparator) + "ffmpeg.sh"
+	if err := ioutil.WriteFile(ffmpeg, []byte("#!/bin/sh\nfor last; do :; done\ncat > /dev/null\nprintf 'not an image' > \"$last\"\n"), 0755); err != nil {
+		t.Fatalf("ioutil.WriteFile: %s", err)
+	}
+	oldPath := FFmpegPath
+	FFmpegPath = ffmpeg
+	defer func() { FFmpegPath = oldPath }()
+
+	req = setupRequestMultipartForm(&testFile{animatedGIFBase64(3), "imageupload", "animated.gif"})
+	fi, err = UploadImageWithOptions(req.MultipartForm.File["imageupload"][0], dir1, dir2, ImageOptions{Jobs: q, Animation: AnimationMP4})
+	if err != nil {
+		t.Fatalf("UploadImageWithOptions(AnimationMP4): Returned an error! [%s]", err)
+	}
+	if jobs, _ := q.Jobs(fi.Name); fi.MimeType != "video/mp4" || len(fi.Processing) > 0 || len(jobs) > 0 {
+		t.Errorf("UploadImageWithOptions(AnimationMP4): A job was added for a video! [%+v %+v]", fi, jobs)
+	}
+	if _, err := os.Stat(dir2 + string(os.PathSeparator) + fi.ThumbnailName); err != nil {
+		t.Errorf("UploadImageWithOptions(AnimationMP4): Thumbnail does not exist! [%s]", err)
+	}
+}
+
+func TestJobQueue_saveError(t *testing.T) {
+	tempDir := "testing-filevalidator-jobs"
+	dir, err := ioutil.TempDir("", tempDir) // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	q, err := NewJobQueue(dir)
+	if err != nil {
+		t.Fatalf("NewJobQueue(): Returned an error! [%s]", err)
+	}
+	logged := &bytes.Buffer{}
+	q.Backoff, q.ErrorLog = 10*time.Millisecond, log.New(logged, "", 0)
+	var calls int
+	q.Handle("work", func(job *Job) error { calls++; return nil })
+	if _, err := q.Enqueue("work", dir, "a.jpg", nil); err != nil {
+		t.Fatalf("JobQueue.Enqueue(): Returned an error! [%s]", err)
+	}
+
+	os.RemoveAll(dir) // the jobs can not be saved anymore
+	if err := q.Start(); err != nil {
+		t.Fatalf("JobQueue.Start(): Returned an error! [%s]", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	q.Stop() // the workers are done, their results can be read
+
+	if calls != 0 {
+		t.Errorf("JobQueue: A job that could not be saved as running was run [%d] times", calls)
+	}
+	if !strings.Contains(logged.String(), "could not be saved") {
+		t.Errorf("JobQueue: The error was not logged [%s]", logged.String())
+	}
+	if jobs, err := q.Jobs("a.jpg"); err != nil || len(jobs) != 1 || jobs[0].Status != JobPending || jobs[0].Attempts != 0 {
+		t.Errorf("JobQueue.Jobs(): Returned[%+v %v]. Expected a pending job", jobs, err)
+	}
+}
+
+func Test_runThumbnailJob(t *testing.T) {
+	tempDir := "testing-filevalidator"
+	dir, err := ioutil.TempDir("", tempDir) // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	image, err := base64.StdEncoding.DecodeString(gopherPNG)
+	if err != nil {
+		t.Fatalf("base64.DecodeString: %s", err)
+	}
+	if err := ioutil.WriteFile(dir+string(os.PathSeparator)+"gopher.png", image, 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %s", err)
+	}
+	params, _ := json.Marshal(thumbnailJobParams{ThumbnailDir: dir, ThumbnailName: "thumbnail.jpg"})
+	job := &Job{Kind: ThumbnailJob, Directory: dir, Name: "gopher.png", Params: params}
+
+	// a retried job finds the thumbnail of the earlier attempt
+	for attempt := 1; attempt <= 2; attempt++ {
+		if err := runThumbnailJob(job); err != nil {
+			t.Errorf("runThumbnailJob(): Attempt %d returned an error! [%s]", attempt, err)
+		}
+	}
+
+	// the file of another upload is kept
+	thumbnail := dir + string(os.PathSeparator) + "thumbnail.jpg"
+	if err := ioutil.WriteFile(thumbnail, []byte("another thumbnail"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %s", err)
+	}
+	if err := runThumbnailJob(job); err != ErrFileExists {
+		t.Errorf("runThumbnailJob(): Returned[%v]. Expected[%s]", err, ErrFileExists)
+	}
+	if b, _ := ioutil.ReadFile(thumbnail); string(b) != "another thumbnail" {
+		t.Errorf("runThumbnailJob(): The existing file was replaced!")
+	}
+}
@@ -0,0 +1,269 @@
+package fileupload
+
+import (
+	"container/heap"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors" // external dependency
+)
+
+var ErrBadCursor = errors.New("The cursor is not valid for this listing!")
+
+type SortField int
+
+const (
+	SortByName SortField = iota
+	SortBySize
+	SortByModTime
+)
+
+// directory entries are read this many at a time
+const listingBatchSize = 256
+
+/*
+	Options for ListDirectory(), the zero value lists the first 100 files by name
+
+	Limit - files per page, 0 is 100
+	Cursor - NextCursor of the previous page, empty for the first page. The sort options must not change between pages
+	SortBy, Descending - the order of the files, files with the same size or time are sorted by name
+	MimeType - a type or a pattern ("image/*"), each file has to be read to find its type
+	MinSize, MaxSize - in bytes, 0 is no limit
+	ModifiedAfter, ModifiedBefore - zero times are no limit
+	Prefix - the start of the file names
+	Details - image sizes, times and thumbnails of the files of the page, see ListingDetails
+*/
+type ListOptions struct {
+	Limit          int
+	Cursor         string
+	SortBy         SortField
+	Descending     bool
+	MimeType       string
+	MinSize        int64
+	MaxSize        int64
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	Prefix         string
+	Details        ListingDetails
+}
+
+// one page of a listing
+type ListPage struct {
+	Files      []FileInfo `json:"files"`
+	NextCursor string     `json:"nextCursor,omitempty"` // empty on the last page
+}
+
+// the position of the last file of a page, base64 JSON in ListPage.NextCursor
+type listCursor struct {
+	SortBy     SortField `json:"s"`
+	Descending bool      `json:"d,omitempty"`
+	Name       string    `json:"n"`
+	Size       int64     `json:"z,omitempty"`
+	ModTime    int64     `json:"t,omitempty"`
+}
+
+// a file found by ListDirectory()
+type listEntry struct {
+	info     os.FileInfo
+	mimetype string
+}
+
+/*
+	List a page of files in a directory, sorted and filtered
+	The directory is read a few entries at a time and only one page is kept in memory, large directories do not have to fit
+	Hidden files (names starting with a dot) and subdirectories are not listed
+*/
+func ListDirectory(directory string, options ListOptions) (*ListPage, error) {
+	root, err := OpenRoot(directory)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	if options.Limit <= 0 {
+		options.Limit = 100
+	}
+
+	var after *listCursor
+	if len(options.Cursor) > 0 {
+		if after, err = decodeListCursor(options.Cursor); err != nil {
+			return nil, err
+		}
+		if after.SortBy != options.SortBy || after.Descending != options.Descending {
+			return nil, ErrBadCursor
+		}
+	}
+
+	dir, err := root.OpenDir()
+	if err != nil {
+		return nil, errors.Wrap(err, "ListDirectory()")
+	}
+	defer dir.Close()
+
+	// keep the first Limit+1 files after the cursor, the extra one shows that there is a next page
+	page := &listHeap{options: &options}
+	for {
+		entries, err := dir.Readdir(listingBatchSize)
+		for _, fi := range entries {
+			entry, ok := options.match(root, fi)
+			if !ok || (after != nil && !options.less(after.entry(), entry)) {
+				continue
+			}
+			heap.Push(page, entry)
+			if page.Len() > options.Limit+1 {
+				heap.Pop(page) // the last one in the order
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "ListDirectory()")
+		}
+	}
+
+	entries := make([]*listEntry, page.Len())
+	for i := len(entries) - 1; i >= 0; i-- {
+		entries[i] = heap.Pop(page).(*listEntry)
+	}
+
+	result := &ListPage{Files: []FileInfo{}}
+	if len(entries) > options.Limit {
+		entries = entries[:options.Limit]
+		if result.NextCursor, err = encodeListCursor(options, entries[len(entries)-1]); err != nil {
+			return nil, errors.Wrap(err, "ListDirectory()")
+		}
+	}
+	var files []listedFile
+	for _, entry := range entries {
+		fi := FileInfo{Name: entry.info.Name(), Size: entry.info.Size(), Directory: directory, MimeType: entry.mimetype, IsImage: isFileImage(entry.mimetype)}
+		if fi.State, err = fileState(root, ".", fi.Name); err != nil { // an unreadable record, the file is listed with the error
+			fi.Error = errors.Cause(err).Error()
+		}
+		result.Files = append(result.Files, fi)
+		files = append(files, listedFile{fi.Name, entry.info})
+	}
+	if err := options.Details.fill(root, files, result.Files); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// check the filters, the mimetype is only read for files that pass the other filters
+func (o *ListOptions) match(root *Root, fi os.FileInfo) (*listEntry, bool) {
+	if strings.HasPrefix(fi.Name(), ".") || !strings.HasPrefix(fi.Name(), o.Prefix) {
+		return nil, false
+	}
+	fi, ok := resolveEntry(root, fi)
+	if !ok || fi.IsDir() {
+		return nil, false
+	}
+	if (o.MinSize > 0 && fi.Size() < o.MinSize) || (o.MaxSize > 0 && fi.Size() > o.MaxSize) {
+		return nil, false
+	}
+	if (!o.ModifiedAfter.IsZero() && !fi.ModTime().After(o.ModifiedAfter)) || (!o.ModifiedBefore.IsZero() && !fi.ModTime().Before(o.ModifiedBefore)) {
+		return nil, false
+	}
+
+	entry := &listEntry{info: fi}
+	if len(o.MimeType) > 0 {
+		file, err := root.Open(fi.Name())
+		if err != nil {
+			return nil, false
+		}
+		entry.mimetype, err = getMimeType(file)
+		file.Close()
+		if err != nil {
+			return nil, false
+		}
+		mediatype, _, _ := mime.ParseMediaType(entry.mimetype) // without "; charset=utf-8"
+		if matched, _ := path.Match(o.MimeType, mediatype); !matched {
+			return nil, false
+		}
+	}
+
+	return entry, true
+}
+
+// the order of the listing, names decide between equal sizes and times
+func (o *ListOptions) less(a, b *listEntry) bool {
+	var less, equal bool
+	switch o.SortBy {
+	case SortBySize:
+		less, equal = a.info.Size() < b.info.Size(), a.info.Size() == b.info.Size()
+	case SortByModTime:
+		less, equal = a.info.ModTime().Before(b.info.ModTime()), a.info.ModTime().Equal(b.info.ModTime())
+	default:
+		equal = true
+	}
+	if equal {
+		less = a.info.Name() < b.info.Name()
+		if a.info.Name() == b.info.Name() {
+			return false
+		}
+	}
+
+	if o.Descending {
+		return !less
+	}
+	return less
+}
+
+func encodeListCursor(options ListOptions, last *listEntry) (string, error) {
+	b, err := json.Marshal(listCursor{SortBy: options.SortBy, Descending: options.Descending, Name: last.info.Name(), Size: last.info.Size(), ModTime: last.info.ModTime().UnixNano()})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeListCursor(cursor string) (*listCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrBadCursor
+	}
+	c := &listCursor{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, ErrBadCursor
+	}
+	return c, nil
+}
+
+// the cursor as an entry, for comparing with the files
+func (c *listCursor) entry() *listEntry {
+	return &listEntry{info: cursorFileInfo{c}}
+}
+
+// the os.FileInfo of a cursor, only the fields used for sorting
+type cursorFileInfo struct {
+	c *listCursor
+}
+
+func (fi cursorFileInfo) Name() string       { return fi.c.Name }
+func (fi cursorFileInfo) Size() int64        { return fi.c.Size }
+func (fi cursorFileInfo) Mode() os.FileMode  { return 0 }
+func (fi cursorFileInfo) ModTime() time.Time { return time.Unix(0, fi.c.ModTime) }
+func (fi cursorFileInfo) IsDir() bool        { return false }
+func (fi cursorFileInfo) Sys() interface{}   { return nil }
+
+// a heap with the last file of the order on top, see container/heap
+type listHeap struct {
+	entries []*listEntry
+	options *ListOptions
+}
+
+func (h *listHeap) Len() int           { return len(h.entries) }
+func (h *listHeap) Less(i, j int) bool { return h.options.less(h.entries[j], h.entries[i]) }
+func (h *listHeap) Swap(i, j int)      { h.entries[i], h.entries[j] = h.entries[j], h.entries[i] }
+func (h *listHeap) Push(x interface{}) { h.entries = append(h.entries, x.(*listEntry)) }
+func (h *listHeap) Pop() interface{} {
+	last := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	return last
+}
@@ -0,0 +1,103 @@
+package fileupload
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListDirectory(t *testing.T) {
+	tempDir := "testing-filevalidator"
+	dir, err := ioutil.TempDir("", tempDir) // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	// 600 files, more than one batch of Readdir(), with sizes and times in reverse order of the names
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 600; i++ {
+		path := dir + string(os.PathSeparator) + fmt.Sprintf("file-%03d.txt", i)
+		if err := ioutil.WriteFile(path, []byte(strings.Repeat("x", 600-i)), 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile: %s", err)
+		}
+		modTime := start.Add(time.Duration(600-i) * time.Second)
+		os.Chtimes(path, modTime, modTime)
+	}
+	if err := ioutil.WriteFile(dir+string(os.PathSeparator)+".hidden", []byte("x"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %s", err)
+	}
+	png, _ := base64.StdEncoding.DecodeString(gopherPNG)
+	if err := ioutil.WriteFile(dir+string(os.PathSeparator)+"gopher.png", png, 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %s", err)
+	}
+
+	// all pages by name
+	var names []string
+	options := ListOptions{Limit: 250}
+	for pages := 0; ; pages++ {
+		page, err := ListDirectory(dir, options)
+		if err != nil {
+			t.Fatalf("ListDirectory(): Returned an error! [%s]", err)
+		}
+		for _, fi := range page.Files {
+			names = append(names, fi.Name)
+		}
+		if len(page.NextCursor) == 0 {
+			if pages != 2 {
+				t.Errorf("ListDirectory(): Returned[%d] pages. Expected 3", pages+1)
+			}
+			break
+		}
+		options.Cursor = page.NextCursor
+	}
+	if len(names) != 601 || names[600] != "gopher.png" {
+		t.Fatalf("ListDirectory(): Returned[%d] files. Expected 601", len(names))
+	}
+	for i, name := range names[:600] {
+		if name != fmt.Sprintf("file-%03d.txt", i) {
+			t.Errorf("ListDirectory(): Wrong order at [%d] [%s]", i, name)
+			break
+		}
+	}
+
+	var list = []struct {
+		options ListOptions
+		first   string
+		count   int
+		more    bool
+	}{
+		{ListOptions{Limit: 10, SortBy: SortBySize}, "file-599.txt", 10, true},
+		{ListOptions{Limit: 10, SortBy: SortBySize, Descending: true}, "gopher.png", 10, true},
+		{ListOptions{Limit: 10, SortBy: SortByModTime}, "file-599.txt", 10, true},
+		{ListOptions{Limit: 10, Descending: true}, "gopher.png", 10, true},
+		{ListOptions{Prefix: "file-01"}, "file-010.txt", 10, false},
+		{ListOptions{MinSize: 100, MaxSize: 109}, "file-491.txt", 10, false},
+		{ListOptions{ModifiedAfter: start.Add(595 * time.Second), ModifiedBefore: start.Add(time.Hour)}, "file-000.txt", 5, false},
+		{ListOptions{MimeType: "image/*"}, "gopher.png", 1, false},
+		{ListOptions{MimeType: "image/png", Prefix: "file-"}, "", 0, false},
+	}
+	for _, l := range list {
+		page, err := ListDirectory(dir, l.options)
+		if err != nil {
+			t.Errorf("ListDirectory(%+v): Returned an error! [%s]", l.options, err)
+			continue
+		}
+		if len(page.Files) != l.count || (len(page.NextCursor) > 0) != l.more || (l.count > 0 && page.Files[0].Name != l.first) {
+			t.Errorf("ListDirectory(%+v): Returned[%d files, more %t]. Expected[%d files starting with %s, more %t]", l.options, len(page.Files), len(page.NextCursor) > 0, l.count, l.first, l.more)
+		}
+	}
+
+	// a cursor only works with the same order
+	page, _ := ListDirectory(dir, ListOptions{Limit: 10, SortBy: SortBySize})
+	if _, err := ListDirectory(dir, ListOptions{Limit: 10, Cursor: page.NextCursor}); err != ErrBadCursor {
+		t.Errorf("ListDirectory(): Should return ErrBadCursor! [%v]", err)
+	}
+	if _, err := ListDirectory(dir, ListOptions{Cursor: "not a cursor"}); err != ErrBadCursor {
+		t.Errorf("ListDirectory(): Should return ErrBadCursor! [%v]", err)
+	}
+}
@@ -0,0 +1,210 @@
+package fileupload
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// the state of one upload, see ProgressRegistry
+type Progress struct {
+	ID       string    `json:"id"`
+	Received int64     `json:"received"`        // bytes
+	Total    int64     `json:"total"`           // bytes, -1 when not known
+	Done     bool      `json:"done"`            // finished or failed
+	Error    string    `json:"error,omitempty"` // of a failed upload
+	Updated  time.Time `json:"updated"`
+}
+
+/*
+	Bytes received by uploads in flight, by an ID chosen by the client (a random string, anyone with the ID can see the progress)
+	The body of a request is counted by Middleware(), the copy of a saved file with UploadOptions.Progress
+	Finished uploads are kept for KeepFinished so the last poll sees them
+	Example:
+	progress := fileupload.NewProgressRegistry()
+	http.Handle("/upload", progress.Middleware(uploadHandler)) // the client sends X-Upload-ID or ?upload_id=
+	http.Handle("/upload-progress", progress.Handler())
+*/
+type ProgressRegistry struct {
+	KeepFinished time.Duration
+	Interval     time.Duration // between Server-Sent Events
+
+	mu      sync.Mutex
+	uploads map[string]*Progress
+}
+
+func NewProgressRegistry() *ProgressRegistry {
+	return &ProgressRegistry{KeepFinished: time.Minute, Interval: 250 * time.Millisecond, uploads: map[string]*Progress{}}
+}
+
+/*
+	Count the bytes read from r as the upload id, total is the expected size or -1
+	A new reader for the same ID starts again from 0, for uploads that are copied in several steps
+*/
+func (p *ProgressRegistry) Reader(id string, r io.Reader, total int64) io.Reader {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.purge()
+	p.uploads[id] = &Progress{ID: id, Total: total, Updated: time.Now()}
+	return &progressReader{registry: p, id: id, r: r}
+}
+
+// mark an upload as finished, err is nil for a successful upload
+func (p *ProgressRegistry) Finish(id string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if upload, ok := p.uploads[id]; ok {
+		upload.Done, upload.Updated = true, time.Now()
+		if err != nil {
+			upload.Error = err.Error()
+		}
+	}
+}
+
+// a copy of the progress of an upload, false for an unknown or expired ID
+func (p *ProgressRegistry) Get(id string) (Progress, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.purge()
+	if upload, ok := p.uploads[id]; ok {
+		return *upload, true
+	}
+	return Progress{}, false
+}
+
+// remove uploads that finished more than KeepFinished ago, called with the lock held
+func (p *ProgressRegistry) purge() {
+	for id, upload := range p.uploads {
+		if upload.Done && time.Since(upload.Updated) > p.KeepFinished {
+			delete(p.uploads, id)
+		}
+	}
+}
+
+func (p *ProgressRegistry) add(id string, n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if upload, ok := p.uploads[id]; ok {
+		upload.Received += int64(n)
+		upload.Updated = time.Now()
+	}
+}
+
+type progressReader struct {
+	registry *ProgressRegistry
+	id       string
+	r        io.Reader
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	if n > 0 {
+		pr.registry.add(pr.id, n)
+	}
+	return n, err
+}
+
+// the upload ID of a request, from the X-Upload-ID header or the upload_id parameter of the URL
+func uploadID(r *http.Request) string {
+	if id := r.Header.Get("X-Upload-ID"); len(id) > 0 {
+		return id
+	}
+	return r.URL.Query().Get("upload_id")
+}
+
+/*
+	Count the request body of uploads with an ID while the next handler reads it, for example with ParseMultipartForm()
+	Requests without an ID are passed on untouched. The upload is finished when the next handler returns
+*/
+func (p *ProgressRegistry) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := uploadID(r)
+		if len(id) == 0 || r.Body == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		total := r.ContentLength
+		if total <= 0 {
+			total = -1
+		}
+		r.Body = wrappedBody{p.Reader(id, r.Body, total), r.Body}
+		defer p.Finish(id, nil)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// a request body read through another reader, still closed by the server
+type wrappedBody struct {
+	io.Reader
+	io.Closer
+}
+
+/*
+	Answers "GET ?id=upload-id" with the Progress of an upload as JSON, 404 for an unknown ID
+	With "Accept: text/event-stream" the progress is sent as Server-Sent Events until the upload is done,
+	an unknown ID is waited for since the client may connect before the upload starts
+*/
+func (p *ProgressRegistry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		id := r.URL.Query().Get("id")
+		w.Header().Set("Cache-Control", "no-store")
+
+		if r.Header.Get("Accept") != "text/event-stream" {
+			progress, ok := p.Get(id)
+			if !ok {
+				http.Error(w, "Unknown upload!", http.StatusNotFound)
+				return
+			}
+			b, err := json.Marshal(progress)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(b)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming is not supported!", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		ticker := time.NewTicker(p.Interval)
+		defer ticker.Stop()
+		var last time.Time
+		for {
+			if progress, ok := p.Get(id); ok && !progress.Updated.Equal(last) {
+				last = progress.Updated
+				b, err := json.Marshal(progress)
+				if err != nil {
+					return
+				}
+				fmt.Fprintf(w, "event: progress\ndata: %s\n\n", b)
+				flusher.Flush()
+				if progress.Done {
+					return
+				}
+			}
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package fileupload
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressRegistry_Middleware(t *testing.T) {
+	progress := NewProgressRegistry()
+
+	var during Progress
+	handler := progress.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			t.Errorf("ParseMultipartForm(): Returned an error! [%s]", err)
+		}
+		during, _ = progress.Get("upload-1")
+	}))
+
+	body, contentType := setupHTTPRequestBody(&testFile{carTARGZ, "fileupload", "car.tar.gz"})
+	size := int64(body.Len())
+	r := httptest.NewRequest("POST", "/upload?upload_id=upload-1", body)
+	r.Header.Set("Content-Type", contentType)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if during.Received != size || during.Total != size || during.Done {
+		t.Errorf("ProgressRegistry.Middleware(): Wrong progress while the body was read [%+v]. Expected %d bytes", during, size)
+	}
+	if after, ok := progress.Get("upload-1"); !ok || !after.Done {
+		t.Errorf("ProgressRegistry.Middleware(): The upload was not finished [%+v]", after)
+	}
+
+	w := httptest.NewRecorder()
+	progress.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/progress?id=upload-1", nil))
+	var polled Progress
+	if err := json.Unmarshal(w.Body.Bytes(), &polled); err != nil || w.Code != http.StatusOK || polled.Received != size || !polled.Done {
+		t.Errorf("ProgressRegistry.Handler(): Returned[%d %s]", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	progress.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/progress?id=upload-2", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("ProgressRegistry.Handler(): An unknown upload returned[%d]. Expected 404", w.Code)
+	}
+}
+
+func TestProgressRegistry_Handler_events(t *testing.T) {
+	progress := NewProgressRegistry()
+	progress.Interval = 10 * time.Millisecond
+	server := httptest.NewServer(progress.Handler())
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL+"?id=upload-1", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req) // connected before the upload starts
+	if err != nil {
+		t.Fatalf("http.Get(): Returned an error! [%s]", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("ProgressRegistry.Handler(): Wrong Content-Type [%s]", resp.Header.Get("Content-Type"))
+	}
+
+	go func() {
+		ioutil.ReadAll(progress.Reader("upload-1", strings.NewReader("0123456789"), 10))
+		time.Sleep(20 * time.Millisecond)
+		progress.Finish("upload-1", nil)
+	}()
+
+	var last Progress
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() { // the stream ends with the finished upload
+		if line := scanner.Text(); strings.HasPrefix(line, "data: ") {
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &last)
+		}
+	}
+	if !last.Done || last.Received != 10 || last.Total != 10 {
+		t.Errorf("ProgressRegistry.Handler(): The last event was [%+v]", last)
+	}
+}
+
+func TestUploadFileWithOptions_progress(t *testing.T) {
+	tempDir := "testing-filevalidator"
+	dir, err := ioutil.TempDir("", tempDir) // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	progress := NewProgressRegistry()
+	req := setupRequestMultipartForm(&testFile{carTARGZ, "fileupload", "car.tar.gz"})
+	fi, err := UploadFileWithOptions(req.MultipartForm.File["fileupload"][0], dir, UploadOptions{Progress: progress, UploadID: "upload-1"})
+	if err != nil {
+		t.Fatalf("UploadFileWithOptions(): Returned an error! [%s]", err)
+	}
+	if p, ok := progress.Get("upload-1"); !ok || !p.Done || p.Received != fi.Size || p.Total != fi.Size || len(p.Error) > 0 {
+		t.Errorf("UploadFileWithOptions(): Wrong progress [%+v]. Expected %d bytes", p, fi.Size)
+	}
+
+	// the hash is taken from the counted bytes, both options are used
+	car, _ := base64.StdEncoding.DecodeString(carTARGZ)
+	sum := sha256.Sum256(car)
+	fi, err = UploadFileWithOptions(req.MultipartForm.File["fileupload"][0], dir, UploadOptions{Progress: progress, UploadID: "upload-2", Hash: true})
+	if err != nil {
+		t.Fatalf("UploadFileWithOptions(): Returned an error! [%s]", err)
+	}
+	if p, ok := progress.Get("upload-2"); !ok || !p.Done || p.Received != fi.Size || fi.Hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("UploadFileWithOptions(Hash): Wrong progress or hash [%+v %s]. Expected %d bytes", p, fi.Hash, fi.Size)
+	}
+}
@@ -0,0 +1,411 @@
+package fileupload
+
+import (
+	"encoding/json"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors" // external dependency
+)
+
+// the lifecycle of a file uploaded to a Quarantine
+type UploadState string
+
+const (
+	StatePending  UploadState = "pending"  // waiting for a scan or a review
+	StateScanning UploadState = "scanning" // the Scanner is running
+	StateApproved UploadState = "approved" // moved to its Category directory, files uploaded without a Quarantine are approved too
+	StateRejected UploadState = "rejected" // the file was deleted, the state is kept with the reason
+)
+
+var ErrUploadNotFound = errors.New("No upload with this name was found!")
+var ErrWrongUploadState = errors.New("The upload is not in the right state for this action!")
+
+// name of the directory with the state of every file, inside the directory of the files
+const stateDirName = ".state"
+
+// the persisted state of one file, stored as JSON in <directory>/.state/<name>.json
+type uploadRecord struct {
+	File    FileInfo    `json:"file"`
+	State   UploadState `json:"state"`
+	Target  string      `json:"target"` // directory of the file once it is approved
+	Reason  string      `json:"reason,omitempty"`
+	Updated time.Time   `json:"updated"`
+}
+
+/*
+	Uploads are first saved to the quarantine directory, they are moved to their Category directory once approved
+	The state of each file is saved next to it, see GetDirectoryContentsData() for listing files by state
+
+	Scanner - used by Scan(), nil uses DefaultScanner. Leave DefaultScanner nil to avoid scanning files twice
+	AutoApprove - files found clean by Scan() are approved right away, otherwise they wait for Approve()
+*/
+type Quarantine struct {
+	Dir         string
+	Scanner     Scanner
+	AutoApprove bool
+
+	mu sync.Mutex
+}
+
+// the directory has to exist, the state directory is created inside it
+func NewQuarantine(dir string) (*Quarantine, error) {
+	root, err := OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	if err := root.MkdirAll(stateDirName, 0755); err != nil {
+		return nil, errors.Wrap(err, "NewQuarantine()")
+	}
+
+	return &Quarantine{Dir: dir}, nil
+}
+
+// save an uploaded file as pending, it is moved to the directory once approved
+func (q *Quarantine) UploadFile(header *multipart.FileHeader, directory string, includeOldExtension bool) (*FileInfo, error) {
+	fi, err := q.UploadFileWithOptions(header, directory, UploadOptions{KeepExtension: includeOldExtension})
+	if err != nil {
+		return fi, errors.Wrap(err, "Quarantine.UploadFile()")
+	}
+	return fi, nil
+}
+
+// save an uploaded file as pending, it is moved to the directory of its Category once approved
+func (q *Quarantine) UploadFileByCategory(header *multipart.FileHeader, list []Category, includeOldExtension bool) (*FileInfo, error) {
+	fi, err := q.UploadFileByCategoryWithOptions(header, list, UploadOptions{KeepExtension: includeOldExtension})
+	if err != nil {
+		return fi, errors.Wrap(err, "Quarantine.UploadFileByCategory()")
+	}
+	return fi, nil
+}
+
+/*
+	Same as UploadFile(), see UploadOptions
+	The owner is charged while the file waits in the quarantine, a rejected file gives the space back
+*/
+func (q *Quarantine) UploadFileWithOptions(header *multipart.FileHeader, directory string, options UploadOptions) (*FileInfo, error) {
+	if _, err := os.Stat(directory); os.IsNotExist(err) { // does the directory exist?
+		return nil, ErrDirectoryDoesNotExist
+	}
+	return q.upload(header, []Category{{[]string{"*"}, directory}}, options)
+}
+
+// same as UploadFileByCategory(), see UploadOptions and UploadFileWithOptions()
+func (q *Quarantine) UploadFileByCategoryWithOptions(header *multipart.FileHeader, list []Category, options UploadOptions) (*FileInfo, error) {
+	return q.upload(header, list, options)
+}
+
+func (q *Quarantine) upload(header *multipart.FileHeader, list []Category, options UploadOptions) (*FileInfo, error) {
+	var target string
+	return uploadFile(header, options, func(mimetype string) (string, error) {
+		var err error
+		target, err = categoryDirectory(list, mimetype) // decided now, the file is only moved later
+		return q.Dir, err
+	}, func(fi *FileInfo) error {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if err := writeUploadRecord(q.Dir, &uploadRecord{File: *fi, State: StatePending, Target: target}); err != nil { // replaces the record of recordOwner()
+			return err
+		}
+		fi.State = StatePending
+		return nil
+	})
+}
+
+/*
+	Scan a pending file with the Scanner, infected files are rejected
+	Clean files are approved with AutoApprove, otherwise they stay pending for a review
+*/
+func (q *Quarantine) Scan(name string) (*FileInfo, error) {
+	if err := q.setState(name, StatePending, StateScanning, ""); err != nil {
+		return nil, errors.Wrap(err, "Quarantine.Scan()")
+	}
+
+	scanner := q.Scanner
+	if scanner == nil {
+		scanner = DefaultScanner
+	}
+
+	var result ScanResult
+	if scanner != nil {
+		root, err := OpenRoot(q.Dir)
+		var f *os.File
+		if err == nil {
+			f, err = root.Open(name)
+			root.Close()
+		}
+		if err == nil {
+			result, err = scanner.Scan(f)
+			f.Close()
+		}
+		if err != nil { // back to pending, the scan can be tried again
+			q.setState(name, StateScanning, StatePending, "")
+			return nil, errors.Wrap(err, "Quarantine.Scan()")
+		}
+	}
+
+	if result.Infected {
+		infected := &InfectedError{result.Signature}
+		if err := q.reject(name, StateScanning, infected.Error()); err != nil {
+			return nil, errors.Wrap(err, "Quarantine.Scan()")
+		}
+		fi, _ := q.State(name)
+		return fi, infected
+	}
+
+	if err := q.setState(name, StateScanning, StatePending, ""); err != nil {
+		return nil, errors.Wrap(err, "Quarantine.Scan()")
+	}
+	if q.AutoApprove {
+		return q.Approve(name)
+	}
+	return q.State(name)
+}
+
+// move a pending file to its Category directory
+func (q *Quarantine) Approve(name string) (*FileInfo, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	record, err := readUploadRecord(q.Dir, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "Quarantine.Approve()")
+	}
+	if record.State != StatePending {
+		return nil, ErrWrongUploadState
+	}
+
+	record.State, record.Reason, record.File.Directory = StateApproved, "", record.Target
+	if err := writeUploadRecord(record.Target, record); err != nil { // the record goes with the file
+		return nil, errors.Wrap(err, "Quarantine.Approve()")
+	}
+	if err := moveFile(q.Dir, record.Target, name); err != nil {
+		removeUploadRecord(record.Target, name)
+		return nil, errors.Wrap(err, "Quarantine.Approve()")
+	}
+	if err := writeUploadRecord(q.Dir, record); err != nil { // keep the history in the quarantine
+		return nil, errors.Wrap(err, "Quarantine.Approve()")
+	}
+
+	return record.fileInfo(), nil
+}
+
+// delete a pending file, the state and the reason are kept
+func (q *Quarantine) Reject(name, reason string) error {
+	if err := q.reject(name, StatePending, reason); err != nil {
+		return errors.Wrap(err, "Quarantine.Reject()")
+	}
+	return nil
+}
+
+func (q *Quarantine) reject(name string, from UploadState, reason string) error {
+	if err := q.setState(name, from, StateRejected, reason); err != nil {
+		return err
+	}
+	if err := removeFromDirectory(q.Dir, name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// the record is kept, only the space of the owner is given back
+	fi, err := q.State(name)
+	if err != nil {
+		return err
+	}
+	if len(fi.Owner) > 0 && DefaultQuota != nil {
+		return DefaultQuota.Release(fi.Owner, fi.Size)
+	}
+	return nil
+}
+
+// the file with its current state
+func (q *Quarantine) State(name string) (*FileInfo, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	record, err := readUploadRecord(q.Dir, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "Quarantine.State()")
+	}
+	return record.fileInfo(), nil
+}
+
+// all files in the quarantine with one of the states, or all of them
+func (q *Quarantine) List(states ...UploadState) ([]FileInfo, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	root, err := OpenRoot(q.Dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "Quarantine.List()")
+	}
+	defer root.Close()
+	entries, err := root.ReadDir(stateDirName)
+	if err != nil {
+		return nil, errors.Wrap(err, "Quarantine.List()")
+	}
+
+	var fis []FileInfo
+	for _, entry := range entries {
+		name := entry.Name()
+		if len(name) <= len(".json") || name[len(name)-len(".json"):] != ".json" {
+			continue
+		}
+		record, err := readUploadRecord(q.Dir, name[:len(name)-len(".json")])
+		if err != nil {
+			return nil, errors.Wrap(err, "Quarantine.List()")
+		}
+		if len(states) == 0 || inStates(states, record.State) {
+			fis = append(fis, *record.fileInfo())
+		}
+	}
+
+	return fis, nil
+}
+
+// change the state if it is still the expected one
+func (q *Quarantine) setState(name string, from, to UploadState, reason string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	record, err := readUploadRecord(q.Dir, name)
+	if err != nil {
+		return err
+	}
+	if record.State != from {
+		return ErrWrongUploadState
+	}
+
+	record.State, record.Reason = to, reason
+	if to == StateRejected {
+		record.File.Error = reason
+	}
+	return writeUploadRecord(q.Dir, record)
+}
+
+func (r *uploadRecord) fileInfo() *FileInfo {
+	fi := r.File
+	fi.State = r.State
+	fi.IsImage = isFileImage(fi.MimeType) // not part of the JSON
+	return &fi
+}
+
+// the name of the record in the directory, names that are not a file in the directory return ErrInvalidFileName
+func uploadRecordName(name string) (string, error) {
+	if err := checkFileName(name); err != nil {
+		return "", err
+	}
+	return stateDirName + string(os.PathSeparator) + name + ".json", nil
+}
+
+func readUploadRecord(directory, name string) (*uploadRecord, error) {
+	if _, err := uploadRecordName(name); err != nil {
+		return nil, err
+	}
+	root, err := OpenRoot(directory)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	return readRootRecord(root, ".", name)
+}
+
+// the record of a file in a directory of an open Root, "." is the directory of the Root
+func readRootRecord(root *Root, dir, name string) (*uploadRecord, error) {
+	recordName, err := uploadRecordName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := root.ReadFile(filepath.Join(dir, recordName))
+	if os.IsNotExist(err) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	record := &uploadRecord{}
+	if err := json.Unmarshal(b, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// write to a temporary file and rename it, a record is never half written
+func writeUploadRecord(directory string, record *uploadRecord) error {
+	recordName, err := uploadRecordName(record.File.Name)
+	if err != nil {
+		return err
+	}
+	root, err := OpenRoot(directory)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	if err := root.MkdirAll(stateDirName, 0755); err != nil {
+		return err
+	}
+
+	record.Updated = time.Now().UTC()
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	f, err := root.CreateTemp(stateDirName, ".record-")
+	if err != nil {
+		return err
+	}
+	tempName := stateDirName + string(os.PathSeparator) + filepath.Base(f.Name())
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = root.Rename(tempName, recordName)
+	}
+	if err != nil {
+		root.Remove(tempName)
+	}
+	return err
+}
+
+// no error when the file has no record
+func removeUploadRecord(directory, name string) error {
+	recordName, err := uploadRecordName(name)
+	if err != nil {
+		return err
+	}
+	if err := removeFromDirectory(directory, recordName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+/*
+	The state of a file in a directory of an open Root, read with the Root of a listing. "." is the directory of the Root
+	Files without a record were uploaded without a Quarantine and are approved
+*/
+func fileState(root *Root, dir, name string) (UploadState, error) {
+	record, err := readRootRecord(root, dir, name)
+	if err == ErrUploadNotFound || err == ErrInvalidFileName { // a name that can not have a record
+		return StateApproved, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return record.State, nil
+}
+
+func inStates(states []UploadState, state UploadState) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
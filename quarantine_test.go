@@ -0,0 +1,182 @@
+package fileupload
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors" // external dependency
+)
+
+func TestQuarantine(t *testing.T) {
+	tempDir1, tempDir2, tempDir3 := "testing-filevalidator-quarantine", "testing-filevalidator-images", "testing-filevalidator-other-uploads"
+	dir1, err := ioutil.TempDir("", tempDir1) // make three temporary directories
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir2, err := ioutil.TempDir("", tempDir2)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir3, err := ioutil.TempDir("", tempDir3)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir1) // delete the temp directories
+	defer os.RemoveAll(dir2)
+	defer os.RemoveAll(dir3)
+
+	q, err := NewQuarantine(dir1)
+	if err != nil {
+		t.Fatalf("NewQuarantine(): Returned an error! [%s]", err)
+	}
+	q.Scanner = &FakeScanner{map[string]string{"Test.Virus": "virus"}}
+
+	list := CatSlice(&Category{[]string{"image/png"}, dir2}, &Category{[]string{"*"}, dir3})
+	req := setupRequestMultipartForm(&testFile{gopherPNG, "fileupload", "gopher.png"}, &testFile{carTARGZ, "fileupload", "car.tar.gz"}, &testFile{base64.StdEncoding.EncodeToString([]byte("a virus")), "fileupload", "bad.txt"})
+	headers := req.MultipartForm.File["fileupload"]
+
+	var fis []*FileInfo
+	for _, header := range headers {
+		fi, err := q.UploadFileByCategory(header, list, true)
+		if err != nil {
+			t.Fatalf("Quarantine.UploadFileByCategory(%s): Returned an error! [%s]", header.Filename, err)
+		}
+		if fi.State != StatePending || fi.Directory != dir1 {
+			t.Errorf("Quarantine.UploadFileByCategory(%s): Should be pending in the quarantine! [%s %s]", header.Filename, fi.State, fi.Directory)
+		}
+		fis = append(fis, fi)
+	}
+
+	if pending, err := GetDirectoryContentsData(dir1, false, StatePending); err != nil || len(pending) != 3 {
+		t.Errorf("GetDirectoryContentsData(StatePending): Returned[%d %v]. Expected 3 files", len(pending), err)
+	}
+
+	// gopher.png is scanned and approved, car.tar.gz waits for a review
+	if fi, err := q.Scan(fis[0].Name); err != nil || fi.State != StatePending {
+		t.Errorf("Quarantine.Scan(gopher.png): Returned[%+v %v]", fi, err)
+	}
+	if fi, err := q.Approve(fis[0].Name); err != nil || fi.State != StateApproved || fi.Directory != dir2 {
+		t.Errorf("Quarantine.Approve(gopher.png): Returned[%+v %v]", fi, err)
+	}
+	if _, err := os.Stat(dir2 + string(os.PathSeparator) + fis[0].Name); err != nil {
+		t.Errorf("Quarantine.Approve(gopher.png): File was not moved! [%s]", err)
+	}
+	if _, err := q.Approve(fis[0].Name); err != ErrWrongUploadState {
+		t.Errorf("Quarantine.Approve(gopher.png): Approved twice! [%v]", err)
+	}
+
+	q.AutoApprove = true
+	if fi, err := q.Scan(fis[1].Name); err != nil || fi.State != StateApproved || fi.Directory != dir3 {
+		t.Errorf("Quarantine.Scan(car.tar.gz): Should be approved! Returned[%+v %v]", fi, err)
+	}
+
+	fi, err := q.Scan(fis[2].Name)
+	if _, ok := err.(*InfectedError); !ok {
+		t.Errorf("Quarantine.Scan(bad.txt): Should return an *InfectedError! Returned[%v]", err)
+	}
+	if fi == nil || fi.State != StateRejected || fi.Error == "" {
+		t.Errorf("Quarantine.Scan(bad.txt): Should be rejected! [%+v]", fi)
+	}
+	if _, err := os.Stat(dir1 + string(os.PathSeparator) + fis[2].Name); !os.IsNotExist(err) {
+		t.Errorf("Quarantine.Scan(bad.txt): Infected file was not deleted!")
+	}
+
+	// the states survive a new Quarantine for the same directory
+	q, _ = NewQuarantine(dir1)
+	if rejected, err := q.List(StateRejected); err != nil || len(rejected) != 1 || rejected[0].Name != fis[2].Name {
+		t.Errorf("Quarantine.List(StateRejected): Returned[%+v %v]", rejected, err)
+	}
+	if all, err := q.List(); err != nil || len(all) != 3 {
+		t.Errorf("Quarantine.List(): Returned[%d %v]. Expected 3 files", len(all), err)
+	}
+	if _, err := q.State("missing"); err == nil {
+		t.Errorf("Quarantine.State(missing): Should return an error!")
+	}
+
+	// names are checked before the record is read, a record outside of the state directory is never used
+	if err := ioutil.WriteFile(dir1+string(os.PathSeparator)+"outside.json", []byte(`{"file":{"name":"outside"},"state":"pending","target":"/"}`), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %s", err)
+	}
+	for _, name := range []string{"../outside", "../../outside", ".state/../../outside"} {
+		if _, err := q.State(name); errors.Cause(err) != ErrInvalidFileName {
+			t.Errorf("Quarantine.State(%s): Returned[%v]. Expected[%s]", name, err, ErrInvalidFileName)
+		}
+		if _, err := q.Approve(name); errors.Cause(err) != ErrInvalidFileName {
+			t.Errorf("Quarantine.Approve(%s): Returned[%v]. Expected[%s]", name, err, ErrInvalidFileName)
+		}
+		if _, err := q.Scan(name); errors.Cause(err) != ErrInvalidFileName {
+			t.Errorf("Quarantine.Scan(%s): Returned[%v]. Expected[%s]", name, err, ErrInvalidFileName)
+		}
+		if err := q.Reject(name, "test"); errors.Cause(err) != ErrInvalidFileName {
+			t.Errorf("Quarantine.Reject(%s): Returned[%v]. Expected[%s]", name, err, ErrInvalidFileName)
+		}
+		if err := ReleaseQuota(dir1, name); errors.Cause(err) != ErrInvalidFileName {
+			t.Errorf("ReleaseQuota(%s): Returned[%v]. Expected[%s]", name, err, ErrInvalidFileName)
+		}
+	}
+
+	if approved, err := GetDirectoryContentsData(dir2, true, StateApproved); err != nil || len(approved) != 1 || approved[0].State != StateApproved {
+		t.Errorf("GetDirectoryContentsData(StateApproved): Returned[%+v %v]", approved, err)
+	}
+	if pending, err := GetDirectoryContentsData(dir1, false, StatePending, StateScanning); err != nil || len(pending) != 0 {
+		t.Errorf("GetDirectoryContentsData(StatePending): Returned[%d %v]. Expected no files", len(pending), err)
+	}
+}
+
+func TestQuarantine_UploadFileWithOptions(t *testing.T) {
+	tempDir1, tempDir2 := "testing-filevalidator-quarantine", "testing-filevalidator-uploads"
+	dir1, err := ioutil.TempDir("", tempDir1) // make two temporary directories
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir2, err := ioutil.TempDir("", tempDir2)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir1) // delete the temp directories
+	defer os.RemoveAll(dir2)
+
+	quota, err := NewFileQuota(dir2+string(os.PathSeparator)+"usage.json", 1<<20)
+	if err != nil {
+		t.Fatalf("NewFileQuota(): Returned an error! [%s]", err)
+	}
+	defer func() { DefaultQuota = nil }()
+	DefaultQuota = quota
+	hook := &testHook{}
+	DefaultHooks.Add(hook)
+	defer DefaultHooks.Clear()
+
+	q, err := NewQuarantine(dir1)
+	if err != nil {
+		t.Fatalf("NewQuarantine(): Returned an error! [%s]", err)
+	}
+
+	var urls int
+	req := setupRequestMultipartForm(&testFile{carTARGZ, "fileupload", "car.tar.gz"})
+	fi, err := q.UploadFileWithOptions(req.MultipartForm.File["fileupload"][0], dir2, UploadOptions{Owner: "alice", Hash: true, SetURLs: func(fi *FileInfo) { urls++ }})
+	if err != nil {
+		t.Fatalf("Quarantine.UploadFileWithOptions(): Returned an error! [%s]", err)
+	}
+	if fi.State != StatePending || fi.Name != "renamed-car.tar.gz" || fi.Owner != "alice" || len(fi.Hash) == 0 || urls != 1 {
+		t.Errorf("Quarantine.UploadFileWithOptions(): Returned wrong data! [%+v %d URLs]", *fi, urls)
+	}
+	if len(hook.calls) != 2 || hook.calls[1] != "store "+fi.Name {
+		t.Errorf("Quarantine.UploadFileWithOptions(): Hooks were not called! [%v]", hook.calls)
+	}
+	if quota.Usage("alice") != fi.Size {
+		t.Errorf("Quarantine.UploadFileWithOptions(): Wrong usage! Usage[%d] Size[%d]", quota.Usage("alice"), fi.Size)
+	}
+
+	// a rejected file gives the space back, its record is kept
+	if err := q.Reject(fi.Name, "test"); err != nil {
+		t.Fatalf("Quarantine.Reject(): Returned an error! [%s]", err)
+	}
+	if quota.Usage("alice") != 0 {
+		t.Errorf("Quarantine.Reject(): The space was not given back! Usage[%d]", quota.Usage("alice"))
+	}
+	if state, err := q.State(fi.Name); err != nil || state.State != StateRejected || state.Owner != "alice" {
+		t.Errorf("Quarantine.State(): Returned[%+v %v]", state, err)
+	}
+}
@@ -0,0 +1,274 @@
+package fileupload
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors" // external dependency
+)
+
+var ErrQuotaExceeded = errors.New("This upload exceeds the storage quota!")
+
+/*
+	Storage limits per owner (user, account, tenant, ...)
+	Reserve() has to check and add in one step, uploads of the same owner can run at the same time
+*/
+type Quota interface {
+	Remaining(owner string) (int64, error)   // bytes the owner can still store
+	Reserve(owner string, bytes int64) error // ErrQuotaExceeded if the owner would go over the limit
+	Release(owner string, bytes int64) error // bytes of a deleted file or of an aborted upload
+}
+
+/*
+	Quota used for uploads with an owner, nil disables quotas. Files without an owner are never counted
+	Example:
+	fileupload.DefaultQuota, err = fileupload.NewFileQuota("/var/lib/app/usage.json", 1<<30)
+*/
+var DefaultQuota Quota
+
+// bytes reserved at once while an upload is copied, the unused part is released at the end
+const quotaStep = 1 << 20
+
+/*
+	A Quota that keeps the usage of every owner in a JSON file, for a single process
+	DefaultLimit - bytes per owner, 0 is no limit. Limits - owners with a different limit
+*/
+type FileQuota struct {
+	Path         string
+	DefaultLimit int64
+	Limits       map[string]int64
+
+	mu    sync.Mutex
+	usage map[string]int64
+}
+
+// the usage is loaded from the file if it exists
+func NewFileQuota(path string, defaultLimit int64) (*FileQuota, error) {
+	q := &FileQuota{Path: path, DefaultLimit: defaultLimit, Limits: map[string]int64{}, usage: map[string]int64{}}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "NewFileQuota()")
+	}
+	if err := json.Unmarshal(b, &q.usage); err != nil {
+		return nil, errors.Wrap(err, "NewFileQuota()")
+	}
+
+	return q, nil
+}
+
+func (q *FileQuota) SetLimit(owner string, limit int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.Limits[owner] = limit
+}
+
+// bytes stored by the owner
+func (q *FileQuota) Usage(owner string) int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.usage[owner]
+}
+
+func (q *FileQuota) Remaining(owner string) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	limit := q.limit(owner)
+	if limit == 0 {
+		return math.MaxInt64, nil
+	}
+	if q.usage[owner] >= limit {
+		return 0, nil
+	}
+	return limit - q.usage[owner], nil
+}
+
+func (q *FileQuota) Reserve(owner string, bytes int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if limit := q.limit(owner); limit > 0 && q.usage[owner]+bytes > limit {
+		return ErrQuotaExceeded
+	}
+	q.usage[owner] += bytes
+	if err := q.save(); err != nil {
+		q.usage[owner] -= bytes
+		return errors.Wrap(err, "FileQuota.Reserve()")
+	}
+	return nil
+}
+
+func (q *FileQuota) Release(owner string, bytes int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.usage[owner] -= bytes
+	if q.usage[owner] <= 0 {
+		delete(q.usage, owner)
+	}
+	if err := q.save(); err != nil {
+		return errors.Wrap(err, "FileQuota.Release()")
+	}
+	return nil
+}
+
+func (q *FileQuota) limit(owner string) int64 {
+	if limit, ok := q.Limits[owner]; ok {
+		return limit
+	}
+	return q.DefaultLimit
+}
+
+// write to a temporary file and rename it, the usage file is never half written
+func (q *FileQuota) save() error {
+	b, err := json.Marshal(q.usage)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(q.Path), ".quota-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), q.Path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// check the size of an upload before anything is read
+func checkQuota(owner string, size int64) error {
+	if len(owner) == 0 || DefaultQuota == nil {
+		return nil
+	}
+
+	remaining, err := DefaultQuota.Remaining(owner)
+	if err != nil {
+		return errors.Wrap(err, "checkQuota()")
+	}
+	if size > remaining {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+/*
+	Reserves the bytes of an upload while they are read, the copy stops with ErrQuotaExceeded as soon as the owner goes over the limit
+	settle() has to be called once the file is saved or the upload failed
+*/
+type quotaReader struct {
+	r        io.Reader
+	owner    string
+	read     int64
+	reserved int64
+}
+
+func newQuotaReader(owner string, r io.Reader) *quotaReader {
+	return &quotaReader{r: r, owner: owner}
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	q.read += int64(n)
+
+	if q.read > q.reserved && len(q.owner) > 0 && DefaultQuota != nil {
+		need, step := q.read-q.reserved, int64(quotaStep)
+		if step < need {
+			step = need
+		}
+		reserveErr := DefaultQuota.Reserve(q.owner, step)
+		if reserveErr == ErrQuotaExceeded && step > need { // close to the limit, try only the bytes that were read
+			step = need
+			reserveErr = DefaultQuota.Reserve(q.owner, step)
+		}
+		if reserveErr != nil {
+			return 0, reserveErr
+		}
+		q.reserved += step
+	}
+
+	return n, err
+}
+
+/*
+	Keep the reservation for the size of the saved file, which can be smaller (sanitized SVG) or larger (re-saved image) than the upload
+	stored - 0 for a failed upload, everything is released
+*/
+func (q *quotaReader) settle(stored int64) error {
+	if len(q.owner) == 0 || DefaultQuota == nil {
+		return nil
+	}
+
+	var err error
+	switch {
+	case stored < q.reserved:
+		err = DefaultQuota.Release(q.owner, q.reserved-stored)
+	case stored > q.reserved:
+		err = DefaultQuota.Reserve(q.owner, stored-q.reserved)
+	}
+	if err == nil {
+		q.reserved = stored
+	}
+	return err
+}
+
+/*
+	Remember the owner of a saved file, in the same record as the Quarantine states
+	The record is used to give the space back when the file is deleted, see ReleaseQuota()
+*/
+func recordOwner(fi *FileInfo, owner string) error {
+	if len(owner) == 0 {
+		return nil
+	}
+	fi.Owner = owner
+
+	record, err := readUploadRecord(fi.Directory, fi.Name)
+	if err == ErrUploadNotFound {
+		record, err = &uploadRecord{State: StateApproved, Target: fi.Directory}, nil
+	}
+	if err != nil {
+		return err
+	}
+	record.File = *fi
+	return writeUploadRecord(fi.Directory, record)
+}
+
+/*
+	Give the space of a deleted file back to its owner and remove the record of the file
+	Called by DeleteFile(), call it after deleting a file some other way. Files without an owner are ignored
+*/
+func ReleaseQuota(directory, name string) error {
+	record, err := readUploadRecord(directory, name)
+	if err == ErrUploadNotFound {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "ReleaseQuota()")
+	}
+
+	if len(record.File.Owner) > 0 && DefaultQuota != nil {
+		if err := DefaultQuota.Release(record.File.Owner, record.File.Size); err != nil {
+			return errors.Wrap(err, "ReleaseQuota()")
+		}
+	}
+	if err := removeUploadRecord(directory, name); err != nil {
+		return errors.Wrap(err, "ReleaseQuota()")
+	}
+	return nil
+}
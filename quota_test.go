@@ -0,0 +1,113 @@
+package fileupload
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFileQuota(t *testing.T) {
+	tempDir := "testing-filevalidator-quota"
+	dir, err := ioutil.TempDir("", tempDir) // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	path := dir + string(os.PathSeparator) + "usage.json"
+	q, err := NewFileQuota(path, 100)
+	if err != nil {
+		t.Fatalf("NewFileQuota(): Returned an error! [%s]", err)
+	}
+	q.SetLimit("big", 0) // no limit
+
+	if err := q.Reserve("alice", 60); err != nil {
+		t.Errorf("FileQuota.Reserve(): Returned an error! [%s]", err)
+	}
+	if err := q.Reserve("alice", 41); err != ErrQuotaExceeded {
+		t.Errorf("FileQuota.Reserve(): Should return ErrQuotaExceeded! [%v]", err)
+	}
+	if err := q.Reserve("big", 1<<40); err != nil {
+		t.Errorf("FileQuota.Reserve(): Returned an error for an owner without a limit! [%s]", err)
+	}
+	if remaining, _ := q.Remaining("alice"); remaining != 40 {
+		t.Errorf("FileQuota.Remaining(): Returned[%d]. Expected 40", remaining)
+	}
+
+	// the usage is kept in the file
+	q, err = NewFileQuota(path, 100)
+	if err != nil {
+		t.Fatalf("NewFileQuota(): Returned an error! [%s]", err)
+	}
+	if usage := q.Usage("alice"); usage != 60 {
+		t.Errorf("FileQuota.Usage(): Returned[%d] after loading the file. Expected 60", usage)
+	}
+	if err := q.Release("alice", 60); err != nil || q.Usage("alice") != 0 {
+		t.Errorf("FileQuota.Release(): Returned[%d %v]", q.Usage("alice"), err)
+	}
+}
+
+func TestUploadFileWithOwner(t *testing.T) {
+	tempDir1, tempDir2 := "testing-filevalidator-uploads", "testing-filevalidator-quota"
+	dir1, err := ioutil.TempDir("", tempDir1) // make two temporary directories
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir2, err := ioutil.TempDir("", tempDir2)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir1) // delete the temp directories
+	defer os.RemoveAll(dir2)
+
+	quota, err := NewFileQuota(dir2+string(os.PathSeparator)+"usage.json", 15000)
+	if err != nil {
+		t.Fatalf("NewFileQuota(): Returned an error! [%s]", err)
+	}
+	defer func() { DefaultQuota = nil }()
+	DefaultQuota = quota
+
+	req := setupRequestMultipartForm(&testFile{carTARGZ, "fileupload", "car.tar.gz"}, &testFile{carTARGZ, "fileupload", "car.tar.gz"}, &testFile{carTARGZ, "fileupload", "car.tar.gz"})
+	headers := req.MultipartForm.File["fileupload"]
+
+	fi, err := UploadFileWithOwner(headers[0], dir1, "alice", true)
+	if err != nil {
+		t.Fatalf("UploadFileWithOwner(): Returned an error! [%s]", err)
+	}
+	if fi.Owner != "alice" || quota.Usage("alice") != fi.Size {
+		t.Errorf("UploadFileWithOwner(): Wrong usage! Owner[%s] Usage[%d] Size[%d]", fi.Owner, quota.Usage("alice"), fi.Size)
+	}
+
+	// checked before the copy with the size of the multipart header
+	if _, err := UploadFileWithOwner(headers[1], dir1, "alice", true); err != ErrQuotaExceeded {
+		t.Errorf("UploadFileWithOwner(): Should return ErrQuotaExceeded! [%v]", err)
+	}
+
+	// a header without the size is stopped during the copy
+	headers[2].Size = 0
+	if _, err := UploadFileByCategoryWithOwner(headers[2], CatSlice(&Category{[]string{"*"}, dir1}), "alice", true); err != ErrQuotaExceeded {
+		t.Errorf("UploadFileByCategoryWithOwner(): Should return ErrQuotaExceeded! [%v]", err)
+	}
+	if quota.Usage("alice") != fi.Size {
+		t.Errorf("UploadFileByCategoryWithOwner(): The aborted upload was counted! Usage[%d]", quota.Usage("alice"))
+	}
+	if files := uploadDirEntries(t, dir1); len(files) != 2 { // the upload and the state directory
+		t.Errorf("UploadFileByCategoryWithOwner(): Files were left after an aborted upload! [%d]", len(files))
+	}
+
+	// other owners and files without an owner are not affected
+	if _, err := UploadFileWithOwner(headers[1], dir1, "bob", true); err != nil {
+		t.Errorf("UploadFileWithOwner(bob): Returned an error! [%s]", err)
+	}
+	if _, err := UploadFile(headers[1], dir1, true); err != nil {
+		t.Errorf("UploadFile(): Returned an error! [%s]", err)
+	}
+
+	// deleting gives the space back
+	if err := os.Remove(dir1 + string(os.PathSeparator) + fi.Name); err != nil {
+		t.Fatalf("os.Remove: %s", err)
+	}
+	if err := ReleaseQuota(dir1, fi.Name); err != nil || quota.Usage("alice") != 0 {
+		t.Errorf("ReleaseQuota(): Returned[%d %v]", quota.Usage("alice"), err)
+	}
+}
@@ -0,0 +1,268 @@
+package fileupload
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/google/uuid" // external dependencies
+	"github.com/pkg/errors"
+)
+
+var ErrPathEscapesRoot = errors.New("The path is outside of the directory!")
+
+/*
+	A directory that file names can not get out of, built on os.Root
+	Names are relative to the directory, absolute paths, ".." and symbolic links that point outside are refused with ErrPathEscapesRoot
+	Links are followed by the kernel while the name is opened, a link swapped in at the same time can not lead outside either
+	Symbolic links with an absolute target are refused, even when they point inside
+	The directory stays open until Close() is called
+*/
+type Root struct {
+	dir  string // absolute, without symbolic links
+	root *os.Root
+}
+
+// the directory has to exist
+func OpenRoot(dir string) (*Root, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "OpenRoot()")
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if os.IsNotExist(err) {
+		return nil, ErrDirectoryDoesNotExist
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "OpenRoot()")
+	}
+	if fi, err := os.Stat(resolved); err != nil || !fi.IsDir() {
+		return nil, ErrDirectoryDoesNotExist
+	}
+
+	root, err := os.OpenRoot(resolved)
+	if os.IsNotExist(err) {
+		return nil, ErrDirectoryDoesNotExist
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "OpenRoot()")
+	}
+
+	return &Root{dir: resolved, root: root}, nil
+}
+
+func (r *Root) Close() error {
+	return r.root.Close()
+}
+
+// the absolute path of the directory, symbolic links resolved. For messages and programs, files are only used through the Root
+func (r *Root) Dir() string {
+	return r.dir
+}
+
+// os.Root does not export the error for names that leave the directory, it is the error of opening ".." in a Root
+var errRootEscape = func() error {
+	root, err := os.OpenRoot(os.TempDir())
+	if err != nil {
+		return nil
+	}
+	defer root.Close()
+	if _, err := root.Open(".."); err != nil {
+		if pathErr, ok := err.(*os.PathError); ok {
+			return pathErr.Err
+		}
+	}
+	return nil
+}()
+
+// ErrPathEscapesRoot for the error of os.Root for names that leave the directory, also inside the *os.PathError of MkdirAll()
+func rootError(err error) error {
+	if err != nil && errRootEscape != nil && errors.Is(err, errRootEscape) {
+		return ErrPathEscapesRoot
+	}
+	return err
+}
+
+func (r *Root) Open(name string) (*os.File, error) {
+	f, err := r.root.Open(name)
+	return f, rootError(err)
+}
+
+func (r *Root) OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	f, err := r.root.OpenFile(name, flag, perm)
+	return f, rootError(err)
+}
+
+/*
+	A new file with a random name in a subdirectory, "" is the directory itself. See ioutil.TempFile()
+	The name of the file in the Root is filepath.Join(dir, filepath.Base(f.Name()))
+*/
+func (r *Root) CreateTemp(dir, pattern string) (*os.File, error) {
+	if filepath.Base(pattern) != pattern {
+		return nil, ErrPathEscapesRoot
+	}
+	for i := 0; ; i++ {
+		f, err := r.OpenFile(filepath.Join(dir, pattern+uuid.New().String()), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if !os.IsExist(err) || i == 100 {
+			return f, err
+		}
+	}
+}
+
+func (r *Root) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return rootError(r.root.WriteFile(name, data, perm))
+}
+
+func (r *Root) ReadFile(name string) ([]byte, error) {
+	b, err := r.root.ReadFile(name)
+	return b, rootError(err)
+}
+
+func (r *Root) Stat(name string) (os.FileInfo, error) {
+	fi, err := r.root.Stat(name)
+	return fi, rootError(err)
+}
+
+func (r *Root) Lstat(name string) (os.FileInfo, error) {
+	fi, err := r.root.Lstat(name)
+	return fi, rootError(err)
+}
+
+// removes a symbolic link itself, not the file it points to
+func (r *Root) Remove(name string) error {
+	return rootError(r.root.Remove(name))
+}
+
+// replaces newName if it exists, see Link() for a new name that is never replaced
+func (r *Root) Rename(oldName, newName string) error {
+	return rootError(r.root.Rename(oldName, newName))
+}
+
+// a second name for a file, fails with an error for which os.IsExist() is true when newName is taken
+func (r *Root) Link(oldName, newName string) error {
+	return rootError(r.root.Link(oldName, newName))
+}
+
+/*
+	Write a file that does not exist yet, a taken name returns ErrFileExists and the file there is kept
+	The data is written in the staging directory and linked to the name, a file under the name is always complete
+*/
+func (r *Root) writeNewFile(name string, data []byte, perm os.FileMode) error {
+	staging := filepath.Join(filepath.Dir(name), stagingDirName)
+	if err := r.MkdirAll(staging, 0755); err != nil {
+		return err
+	}
+	f, err := r.CreateTemp(staging, ".new-")
+	if err != nil {
+		return err
+	}
+	tempName := filepath.Join(staging, filepath.Base(f.Name()))
+	defer r.Remove(tempName) // the file is kept under its name
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := r.Link(tempName, name); os.IsExist(err) {
+		return ErrFileExists
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// a subdirectory, no error when it already exists
+func (r *Root) MkdirAll(name string, perm os.FileMode) error {
+	return rootError(r.root.MkdirAll(name, perm))
+}
+
+// remove a file from a directory, used to clean up after failed uploads
+func removeFromDirectory(directory, name string) error {
+	root, err := OpenRoot(directory)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	return root.Remove(name)
+}
+
+/*
+	Move a file between two directories, the name has to stay inside both and must not exist in toDirectory
+	os.Root can not rename between directories, the file is copied and removed. The directories can be on different file systems
+*/
+func moveFile(fromDirectory, toDirectory, name string) error {
+	from, err := OpenRoot(fromDirectory)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	to, err := OpenRoot(toDirectory)
+	if err != nil {
+		return err
+	}
+	defer to.Close()
+
+	return moveBetweenRoots(from, name, to, name)
+}
+
+// see moveFile(), the file gets a new name in the other directory
+func moveBetweenRoots(from *Root, fromName string, to *Root, toName string) error {
+	src, err := from.Open(fromName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	stat, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	dst, err := to.OpenFile(toName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, stat.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		to.Remove(toName)
+		return err
+	}
+
+	return from.Remove(fromName)
+}
+
+// the entries of the directory sorted by name, symbolic links are not followed
+func (r *Root) Readdir() ([]os.FileInfo, error) {
+	return r.ReadDir(".")
+}
+
+// the entries of a subdirectory sorted by name, symbolic links are not followed
+func (r *Root) ReadDir(name string) ([]os.FileInfo, error) {
+	dir, err := r.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	entries, err := dir.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// the directory itself, for reading large directories a few entries at a time with Readdir(n)
+func (r *Root) OpenDir() (*os.File, error) {
+	return r.Open(".")
+}
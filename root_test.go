@@ -0,0 +1,151 @@
+package fileupload
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors" // external dependency
+)
+
+func TestRoot(t *testing.T) {
+	tempDir1, tempDir2 := "testing-filevalidator-root", "testing-filevalidator-outside"
+	dir1, err := ioutil.TempDir("", tempDir1) // make two temporary directories
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir2, err := ioutil.TempDir("", tempDir2)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir1) // delete the temp directories
+	defer os.RemoveAll(dir2)
+
+	sep := string(os.PathSeparator)
+	if err := ioutil.WriteFile(dir2+sep+"secret.txt", []byte("secret"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %s", err)
+	}
+	if err := ioutil.WriteFile(dir1+sep+"inside.txt", []byte("inside"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %s", err)
+	}
+	if err := os.Mkdir(dir1+sep+"sub", 0755); err != nil {
+		t.Fatalf("os.Mkdir: %s", err)
+	}
+	links := map[string]string{"escape": dir2 + sep + "secret.txt", "escapedir": dir2, "dangling": dir2 + sep + "new.txt", "link.txt": "inside.txt", "sub" + sep + "up.txt": ".." + sep + "inside.txt"}
+	for name, target := range links {
+		if err := os.Symlink(target, dir1+sep+name); err != nil {
+			t.Skipf("os.Symlink: %s", err)
+		}
+	}
+
+	root, err := OpenRoot(dir1)
+	if err != nil {
+		t.Fatalf("OpenRoot(): Returned an error! [%s]", err)
+	}
+	if _, err := OpenRoot(dir1 + sep + "missing"); err != ErrDirectoryDoesNotExist {
+		t.Errorf("OpenRoot(missing): Should return ErrDirectoryDoesNotExist! [%v]", err)
+	}
+
+	var list = []struct {
+		name   string
+		escape bool
+	}{
+		{"inside.txt", false},
+		{"new.txt", false},
+		{"link.txt", false},
+		{"sub" + sep + "up.txt", false},
+		{"sub" + sep + ".." + sep + "inside.txt", false},
+		{".." + sep + tempDir2, true},
+		{"sub" + sep + ".." + sep + ".." + sep + "x", true},
+		{dir2 + sep + "secret.txt", true},
+		{"escape", true},
+		{"escapedir" + sep + "secret.txt", true},
+		{"escapedir" + sep + "new.txt", true},
+		{"dangling", true},
+	}
+	for _, l := range list {
+		_, err := root.Stat(l.name)
+		if (err == ErrPathEscapesRoot) != l.escape {
+			t.Errorf("Root.Stat(%s): Returned[%v]. Expected escape[%t]", l.name, err, l.escape)
+		}
+	}
+
+	if _, err := root.Open("escape"); err != ErrPathEscapesRoot {
+		t.Errorf("Root.Open(escape): Should return ErrPathEscapesRoot! [%v]", err)
+	}
+	if err := root.WriteFile("dangling", []byte("x"), 0644); err != ErrPathEscapesRoot {
+		t.Errorf("Root.WriteFile(dangling): Should return ErrPathEscapesRoot! [%v]", err)
+	}
+	if _, err := os.Stat(dir2 + sep + "new.txt"); !os.IsNotExist(err) {
+		t.Errorf("Root.WriteFile(dangling): A file was created outside of the directory!")
+	}
+
+	// links are removed, not the files they point to
+	if err := root.Remove("escape"); err != nil {
+		t.Errorf("Root.Remove(escape): Returned an error! [%s]", err)
+	}
+	if _, err := os.Stat(dir2 + sep + "secret.txt"); err != nil {
+		t.Errorf("Root.Remove(escape): The file outside of the directory was removed! [%s]", err)
+	}
+	if err := root.Remove(".." + sep + tempDir2); err != ErrPathEscapesRoot {
+		t.Errorf("Root.Remove(..): Should return ErrPathEscapesRoot! [%v]", err)
+	}
+	if err := root.Rename("inside.txt", ".."+sep+"moved.txt"); err != ErrPathEscapesRoot {
+		t.Errorf("Root.Rename(..): Should return ErrPathEscapesRoot! [%v]", err)
+	}
+	if err := root.Link("inside.txt", "escapedir"+sep+"linked.txt"); err != ErrPathEscapesRoot {
+		t.Errorf("Root.Link(escapedir): Should return ErrPathEscapesRoot! [%v]", err)
+	}
+
+	// the state directory of a Quarantine is never a link to another directory
+	if err := os.Symlink(dir2, dir1+sep+stateDirName); err != nil {
+		t.Fatalf("os.Symlink: %s", err)
+	}
+	if _, err := NewQuarantine(dir1); errors.Cause(err) != ErrPathEscapesRoot {
+		t.Errorf("NewQuarantine(): Returned[%v]. Expected[%s]", err, ErrPathEscapesRoot)
+	}
+}
+
+func TestGetDirectoryContentsData_symlinks(t *testing.T) {
+	tempDir1, tempDir2 := "testing-filevalidator-root", "testing-filevalidator-outside"
+	dir1, err := ioutil.TempDir("", tempDir1) // make two temporary directories
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir2, err := ioutil.TempDir("", tempDir2)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir1) // delete the temp directories
+	defer os.RemoveAll(dir2)
+
+	sep := string(os.PathSeparator)
+	if err := ioutil.WriteFile(dir2+sep+"secret.html", []byte("<html>secret</html>"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %s", err)
+	}
+	if err := ioutil.WriteFile(dir1+sep+"inside.txt", []byte("inside"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %s", err)
+	}
+	if err := os.Symlink(dir2+sep+"secret.html", dir1+sep+"escape"); err != nil {
+		t.Skipf("os.Symlink: %s", err)
+	}
+	if err := os.Symlink("inside.txt", dir1+sep+"link.txt"); err != nil {
+		t.Skipf("os.Symlink: %s", err)
+	}
+
+	fis, err := GetDirectoryContentsData(dir1, true)
+	if err != nil {
+		t.Fatalf("GetDirectoryContentsData(): Returned an error! [%s]", err)
+	}
+	if len(fis) != 2 {
+		t.Errorf("GetDirectoryContentsData(): Returned[%d] files. Expected 2, the link outside is left out", len(fis))
+	}
+	for _, fi := range fis {
+		if fi.Name == "escape" {
+			t.Errorf("GetDirectoryContentsData(): Listed a link outside of the directory!")
+		}
+		if fi.Name == "link.txt" && fi.Size != 6 {
+			t.Errorf("GetDirectoryContentsData(): Wrong size for a link! [%d]", fi.Size)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package fileupload
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors" // external dependency
+)
+
+// a virus/malware scanner, called for every uploaded file before it is saved under its final name
+type Scanner interface {
+	Scan(r io.Reader) (ScanResult, error)
+}
+
+type ScanResult struct {
+	Infected  bool
+	Signature string // name of the found virus/malware
+}
+
+/*
+	Scanner used by all upload functions, nil disables scanning
+	Example:
+	fileupload.DefaultScanner = fileupload.NewClamdScanner("unix", "/run/clamav/clamd.ctl")
+*/
+var DefaultScanner Scanner
+
+// infected files are moved to this directory, if empty they are deleted
+var InfectedFilesDir string
+
+// returned by the upload functions when the scanner finds something, FileInfo.Error has the same message
+type InfectedError struct {
+	Signature string
+}
+
+func (e *InfectedError) Error() string {
+	return "This file is infected! [" + e.Signature + "]"
+}
+
+// returned together with an *InfectedError, the file was not saved
+func infectedFileInfo(err *InfectedError, newName, oldName, mimetype string) *FileInfo {
+	return &FileInfo{Name: newName, OriginalName: oldName, MimeType: mimetype, IsImage: isFileImage(mimetype), Error: err.Error()}
+}
+
+/*
+	Scan a file that was written to a temporary name in the Root
+	Infected files are moved to InfectedFilesDir or deleted, the temporary file is always gone when an error is returned
+*/
+func scanTempFile(root *Root, tempName, name string) error {
+	if DefaultScanner == nil {
+		return nil
+	}
+
+	f, err := root.Open(tempName)
+	if err != nil {
+		root.Remove(tempName)
+		return errors.Wrap(err, "scanTempFile()")
+	}
+	result, err := DefaultScanner.Scan(f)
+	f.Close()
+	if err != nil { // a file that could not be scanned is not kept
+		root.Remove(tempName)
+		return errors.Wrap(err, "scanTempFile()")
+	}
+	if !result.Infected {
+		return nil
+	}
+
+	if len(InfectedFilesDir) > 0 {
+		if infected, err := OpenRoot(InfectedFilesDir); err == nil {
+			err = moveBetweenRoots(root, tempName, infected, name)
+			infected.Close()
+			if err == nil {
+				return &InfectedError{result.Signature}
+			}
+		}
+	}
+	root.Remove(tempName)
+	return &InfectedError{result.Signature}
+}
+
+/*
+	Scan an uploaded file kept in memory, used by the image functions before anything is written
+	Infected files are saved to InfectedFilesDir, if it is set
+*/
+func scanBuffer(buffer []byte, name string) error {
+	if DefaultScanner == nil {
+		return nil
+	}
+
+	result, err := DefaultScanner.Scan(bytes.NewReader(buffer))
+	if err != nil {
+		return errors.Wrap(err, "scanBuffer()")
+	}
+	if !result.Infected {
+		return nil
+	}
+
+	if len(InfectedFilesDir) > 0 {
+		if root, err := OpenRoot(InfectedFilesDir); err == nil {
+			if f, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600); err == nil {
+				f.Write(buffer)
+				f.Close()
+			}
+			root.Close()
+		}
+	}
+	return &InfectedError{result.Signature}
+}
+
+/*
+	Client for the clamd daemon of ClamAV, files are sent with the INSTREAM command
+	network - "tcp" or "unix", address - "127.0.0.1:3310" or "/run/clamav/clamd.ctl"
+	The file size is limited by StreamMaxLength in clamd.conf, larger files return an error
+*/
+type ClamdScanner struct {
+	Network   string
+	Address   string
+	Timeout   time.Duration // for the whole scan, 0 is no timeout
+	ChunkSize int
+}
+
+func NewClamdScanner(network, address string) *ClamdScanner {
+	return &ClamdScanner{Network: network, Address: address, Timeout: 2 * time.Minute, ChunkSize: 64 * 1024}
+}
+
+func (c *ClamdScanner) Scan(r io.Reader) (ScanResult, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return ScanResult{}, errors.Wrap(err, "ClamdScanner.Scan()")
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return ScanResult{}, errors.Wrap(err, "ClamdScanner.Scan()")
+	}
+
+	// chunks: 4 byte big endian length followed by the data, a zero length ends the stream
+	chunkSize := c.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = 64 * 1024
+	}
+	chunk := make([]byte, 4+chunkSize)
+	for {
+		n, err := io.ReadFull(r, chunk[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(chunk, uint32(n))
+			if _, err := conn.Write(chunk[:4+n]); err != nil {
+				return ScanResult{}, errors.Wrap(err, "ClamdScanner.Scan()")
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return ScanResult{}, errors.Wrap(err, "ClamdScanner.Scan()")
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return ScanResult{}, errors.Wrap(err, "ClamdScanner.Scan()")
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return ScanResult{}, errors.Wrap(err, "ClamdScanner.Scan()")
+	}
+
+	return parseClamdReply(reply)
+}
+
+// check the connection to clamd
+func (c *ClamdScanner) Ping() error {
+	conn, err := c.dial()
+	if err != nil {
+		return errors.Wrap(err, "ClamdScanner.Ping()")
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("zPING\x00")); err != nil {
+		return errors.Wrap(err, "ClamdScanner.Ping()")
+	}
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return errors.Wrap(err, "ClamdScanner.Ping()")
+	}
+	if strings.TrimRight(reply, "\x00") != "PONG" {
+		return errors.Errorf("ClamdScanner.Ping(): Unexpected reply [%s]", reply)
+	}
+
+	return nil
+}
+
+func (c *ClamdScanner) dial() (net.Conn, error) {
+	conn, err := net.DialTimeout(c.Network, c.Address, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if c.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+	return conn, nil
+}
+
+// "stream: OK", "stream: Eicar-Signature FOUND" or "... ERROR"
+func parseClamdReply(reply string) (ScanResult, error) {
+	reply = strings.TrimSpace(strings.TrimRight(reply, "\x00"))
+	reply = strings.TrimPrefix(reply, "stream: ")
+
+	switch {
+	case reply == "OK":
+		return ScanResult{}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return ScanResult{Infected: true, Signature: strings.TrimSuffix(reply, " FOUND")}, nil
+	}
+
+	return ScanResult{}, errors.Errorf("parseClamdReply(): clamd returned an error [%s]", reply)
+}
+
+/*
+	A scanner for tests, files containing one of the patterns are infected
+	Example: &FakeScanner{map[string]string{"Test-Signature": "not a real virus"}}
+*/
+type FakeScanner struct {
+	Signatures map[string]string // signature name => pattern
+}
+
+func (s *FakeScanner) Scan(r io.Reader) (ScanResult, error) {
+	buffer := &bytes.Buffer{}
+	if _, err := io.Copy(buffer, r); err != nil {
+		return ScanResult{}, errors.Wrap(err, "FakeScanner.Scan()")
+	}
+
+	for signature, pattern := range s.Signatures {
+		if bytes.Contains(buffer.Bytes(), []byte(pattern)) {
+			return ScanResult{Infected: true, Signature: signature}, nil
+		}
+	}
+	return ScanResult{}, nil
+}
@@ -0,0 +1,146 @@
+package fileupload
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_parseClamdReply(t *testing.T) {
+	var list = []struct {
+		reply     string
+		infected  bool
+		signature string
+		err       bool
+	}{
+		{"stream: OK\x00", false, "", false},
+		{"stream: Eicar-Test-Signature FOUND\x00", true, "Eicar-Test-Signature", false},
+		{"INSTREAM size limit exceeded. ERROR\x00", false, "", true},
+		{"", false, "", true},
+	}
+	for _, l := range list {
+		result, err := parseClamdReply(l.reply)
+		if (err != nil) != l.err || result.Infected != l.infected || result.Signature != l.signature {
+			t.Errorf("parseClamdReply(%q): Returned[%+v %v]", l.reply, result, err)
+		}
+	}
+}
+
+// a minimal clamd, reads one INSTREAM command and reports files containing "virus"
+func fakeClamd(t *testing.T, l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		r := bufio.NewReader(conn)
+		command, err := r.ReadString(0)
+		if err != nil || command != "zINSTREAM\x00" {
+			t.Errorf("fakeClamd: Unexpected command [%q]", command)
+			conn.Close()
+			continue
+		}
+
+		data := &bytes.Buffer{}
+		size := make([]byte, 4)
+		for {
+			if _, err := io.ReadFull(r, size); err != nil {
+				t.Errorf("fakeClamd: %s", err)
+				break
+			}
+			n := binary.BigEndian.Uint32(size)
+			if n == 0 {
+				break
+			}
+			io.CopyN(data, r, int64(n))
+		}
+
+		if strings.Contains(data.String(), "virus") {
+			conn.Write([]byte("stream: Test.Virus FOUND\x00"))
+		} else {
+			conn.Write([]byte("stream: OK\x00"))
+		}
+		conn.Close()
+	}
+}
+
+func Test_ClamdScanner(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %s", err)
+	}
+	defer l.Close()
+	go fakeClamd(t, l)
+
+	scanner := NewClamdScanner("tcp", l.Addr().String())
+	scanner.ChunkSize = 7 // several chunks
+
+	result, err := scanner.Scan(strings.NewReader("a clean file, longer than one chunk"))
+	if err != nil || result.Infected {
+		t.Errorf("ClamdScanner.Scan(): Returned[%+v %v]. Expected a clean file", result, err)
+	}
+
+	result, err = scanner.Scan(strings.NewReader("this file has a virus in it"))
+	if err != nil || !result.Infected || result.Signature != "Test.Virus" {
+		t.Errorf("ClamdScanner.Scan(): Returned[%+v %v]. Expected Test.Virus", result, err)
+	}
+}
+
+func TestUploadFile_scanner(t *testing.T) {
+	tempDir1, tempDir2 := "testing-filevalidator-uploads", "testing-filevalidator-infected"
+	dir1, err := ioutil.TempDir("", tempDir1) // make two temporary directories
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir2, err := ioutil.TempDir("", tempDir2)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir1) // delete the temp directories
+	defer os.RemoveAll(dir2)
+
+	defer func() { DefaultScanner, InfectedFilesDir = nil, "" }()
+	DefaultScanner = &FakeScanner{map[string]string{"Test.Virus": "virus"}}
+	InfectedFilesDir = dir2
+
+	req := setupRequestMultipartForm(&testFile{base64.StdEncoding.EncodeToString([]byte("a virus")), "fileupload", "bad.txt"}, &testFile{carTARGZ, "fileupload", "car.tar.gz"})
+	headers := req.MultipartForm.File["fileupload"]
+
+	fi, err := UploadFile(headers[0], dir1, true)
+	if _, ok := err.(*InfectedError); !ok {
+		t.Fatalf("UploadFile(bad.txt): Should return an *InfectedError! Returned[%v]", err)
+	}
+	if fi == nil || fi.Error != err.Error() {
+		t.Errorf("UploadFile(bad.txt): FileInfo.Error should be set! [%+v]", fi)
+	}
+	if _, err := os.Stat(dir2 + string(os.PathSeparator) + fi.Name); err != nil { // moved to the infected files directory
+		t.Errorf("UploadFile(bad.txt): Infected file was not moved! [%s]", err)
+	}
+
+	if fi, err = UploadFile(headers[1], dir1, true); err != nil {
+		t.Fatalf("UploadFile(car.tar.gz): Returned an error! [%s]", err)
+	}
+	if files := uploadDirEntries(t, dir1); len(files) != 1 || files[0].Name() != fi.Name { // no temporary files are left
+		t.Errorf("UploadFile(): Wrong files in the upload directory! [%d]", len(files))
+	}
+
+	// images are scanned before anything is written
+	req = setupRequestMultipartForm(&testFile{gopherPNG, "imageupload", "gopher.png"})
+	DefaultScanner = &FakeScanner{map[string]string{"Test.PNG": "\x89PNG"}}
+	if _, err := UploadImageWithThumbnail(req.MultipartForm.File["imageupload"][0], dir1, dir1); err == nil {
+		t.Errorf("UploadImageWithThumbnail(): Should return an *InfectedError!")
+	} else if _, ok := err.(*InfectedError); !ok {
+		t.Errorf("UploadImageWithThumbnail(): Should return an *InfectedError! Returned[%s]", err)
+	}
+	if files := uploadDirEntries(t, dir1); len(files) != 1 {
+		t.Errorf("UploadImageWithThumbnail(): Files were saved for an infected image! [%d]", len(files))
+	}
+}
@@ -0,0 +1,222 @@
+package fileupload
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors" // external dependency
+)
+
+var ErrNoSigningKey = errors.New("No signing key was given!")
+var ErrBadSignature = errors.New("The signature of the URL is not valid!")
+var ErrURLExpired = errors.New("The URL has expired!")
+
+// query parameters added to signed URLs
+const (
+	signatureParam = "sig"
+	expiresParam   = "expires"
+	keyIDParam     = "kid"
+	bindParam      = "bind"
+)
+
+// a secret for signing URLs, the ID is put in the URL to find the key again
+type SigningKey struct {
+	ID     string
+	Secret []byte // at least 32 random bytes
+}
+
+// the request a signed URL is limited to, empty fields are not checked
+type URLBinding struct {
+	IP   string
+	User string
+}
+
+/*
+	Signs URLs with HMAC-SHA256, the signature covers the path, the query, the expiry time and the binding
+	The first key signs new URLs, all keys are accepted. For a rotation add the new key first and remove the old one once its URLs have expired
+
+	UserFunc - the user of a request, needed to verify URLs bound to a user
+	ClientIP - the IP of a request, the default is the address of the connection (see the X-Forwarded-For of a proxy)
+*/
+type URLSigner struct {
+	UserFunc func(r *http.Request) string
+	ClientIP func(r *http.Request) string
+
+	mu    sync.RWMutex
+	keys  []SigningKey
+	spent map[string]time.Time // ID and expiry of used tickets, see SpendTicket()
+}
+
+func NewURLSigner(keys ...SigningKey) *URLSigner {
+	return &URLSigner{keys: keys}
+}
+
+// replace the keys, the first one signs new URLs
+func (s *URLSigner) SetKeys(keys ...SigningKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+/*
+	Add expires, kid, bind and sig parameters to a URL, it can be relative ("/download?file=x.jpg")
+	Example:
+	link, err := signer.Sign(fi.Url, time.Hour, fileupload.URLBinding{User: userID})
+*/
+func (s *URLSigner) Sign(rawURL string, ttl time.Duration, binding URLBinding) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.keys) == 0 {
+		return "", ErrNoSigningKey
+	}
+	key := s.keys[0]
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", errors.Wrap(err, "URLSigner.Sign()")
+	}
+	query := u.Query()
+	for _, param := range []string{signatureParam, expiresParam, keyIDParam, bindParam} {
+		query.Del(param)
+	}
+
+	query.Set(expiresParam, strconv.FormatInt(time.Now().Add(ttl).Unix(), 10))
+	query.Set(keyIDParam, key.ID)
+	var bind []string
+	if len(binding.IP) > 0 {
+		bind = append(bind, "ip")
+	}
+	if len(binding.User) > 0 {
+		bind = append(bind, "user")
+	}
+	if len(bind) > 0 {
+		query.Set(bindParam, strings.Join(bind, ","))
+	}
+
+	query.Set(signatureParam, signURL(key.Secret, u.Path, query, binding))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// sign the Url, DeleteUrl and DeleteNoJSUrl of a FileInfo that are set
+func (s *URLSigner) SignFileInfo(fi *FileInfo, ttl time.Duration, binding URLBinding) error {
+	for _, link := range []*string{&fi.Url, &fi.DeleteUrl, &fi.DeleteNoJSUrl} {
+		if len(*link) == 0 {
+			continue
+		}
+		signed, err := s.Sign(*link, ttl, binding)
+		if err != nil {
+			return errors.Wrap(err, "URLSigner.SignFileInfo()")
+		}
+		*link = signed
+	}
+	return nil
+}
+
+/*
+	Check the signature of a request URL
+	Returns ErrBadSignature (also for an unknown key or a different IP/user) and ErrURLExpired
+*/
+func (s *URLSigner) Verify(r *http.Request) error {
+	return s.verify(r.URL, s.requestBinding(r))
+}
+
+// the IP and the user of a request, for the bindings named in the URL
+func (s *URLSigner) requestBinding(r *http.Request) URLBinding {
+	var binding URLBinding
+	for _, bind := range strings.Split(r.URL.Query().Get(bindParam), ",") {
+		switch bind {
+		case "ip":
+			binding.IP = s.clientIP(r)
+		case "user":
+			if s.UserFunc != nil {
+				binding.User = s.UserFunc(r)
+			}
+		}
+	}
+	return binding
+}
+
+func (s *URLSigner) verify(u *url.URL, binding URLBinding) error {
+	query := u.Query()
+	signature := query.Get(signatureParam)
+	query.Del(signatureParam)
+
+	expires, err := strconv.ParseInt(query.Get(expiresParam), 10, 64)
+	if err != nil || len(signature) == 0 {
+		return ErrBadSignature
+	}
+	if strings.Contains(query.Get(bindParam), "ip") && len(binding.IP) == 0 {
+		return ErrBadSignature
+	}
+	if strings.Contains(query.Get(bindParam), "user") && len(binding.User) == 0 {
+		return ErrBadSignature // not logged in
+	}
+
+	secret := s.secret(query.Get(keyIDParam))
+	if secret == nil {
+		return ErrBadSignature
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(signURL(secret, u.Path, query, binding))) {
+		return ErrBadSignature
+	}
+	if time.Now().Unix() > expires { // checked after the signature, the time can not be changed
+		return ErrURLExpired
+	}
+	return nil
+}
+
+/*
+	Only pass requests with a valid signed URL to the handler, others get 403 Forbidden
+	Only the URL is verified, the handler must not read parameters from the body (DownloadHandler and DeleteHandler do not)
+	Example:
+	http.Handle("/download", signer.Middleware(fileupload.DownloadHandler(fileupload.DirectoryLookup("uploads"))))
+*/
+func (s *URLSigner) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.Verify(r); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// the secret of a key, nil for unknown keys
+func (s *URLSigner) secret(id string) []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range s.keys {
+		if key.ID == id {
+			return key.Secret
+		}
+	}
+	return nil
+}
+
+func (s *URLSigner) clientIP(r *http.Request) string {
+	if s.ClientIP != nil {
+		return s.ClientIP(r)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// HMAC-SHA256 of the path, the sorted query without the signature and the binding
+func signURL(secret []byte, path string, query url.Values, binding URLBinding) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(path + "?" + query.Encode() + "\n" + binding.IP + "\n" + binding.User))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,133 @@
+package fileupload
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestURLSigner(t *testing.T) {
+	oldKey := SigningKey{"2025", []byte("an old secret that is long enough!!")}
+	newKey := SigningKey{"2026", []byte("a new secret that is also long enough")}
+	signer := NewURLSigner(oldKey)
+	signer.UserFunc = func(r *http.Request) string { return r.Header.Get("X-User") }
+
+	request := func(link, ip, user string) *http.Request {
+		r := httptest.NewRequest("GET", link, nil)
+		r.RemoteAddr = ip + ":1234"
+		r.Header.Set("X-User", user)
+		return r
+	}
+
+	link, err := signer.Sign("/download?file=a.jpg", time.Hour, URLBinding{})
+	if err != nil {
+		t.Fatalf("URLSigner.Sign(): Returned an error! [%s]", err)
+	}
+	if err := signer.Verify(request(link, "10.0.0.1", "")); err != nil {
+		t.Errorf("URLSigner.Verify(): Returned an error! [%s]", err)
+	}
+
+	// changed URLs
+	for _, changed := range []string{
+		strings.Replace(link, "a.jpg", "b.jpg", 1),
+		strings.Replace(link, "/download", "/delete", 1),
+		"/download?file=a.jpg",
+	} {
+		if err := signer.Verify(request(changed, "10.0.0.1", "")); err != ErrBadSignature {
+			t.Errorf("URLSigner.Verify(%s): Should return ErrBadSignature! [%v]", changed, err)
+		}
+	}
+	u, _ := url.Parse(link)
+	query := u.Query()
+	query.Set(expiresParam, "9999999999")
+	u.RawQuery = query.Encode()
+	if err := signer.Verify(request(u.String(), "10.0.0.1", "")); err != ErrBadSignature {
+		t.Errorf("URLSigner.Verify(): A later expiry time should return ErrBadSignature! [%v]", err)
+	}
+
+	expired, _ := signer.Sign("/download?file=a.jpg", -time.Minute, URLBinding{})
+	if err := signer.Verify(request(expired, "10.0.0.1", "")); err != ErrURLExpired {
+		t.Errorf("URLSigner.Verify(): Should return ErrURLExpired! [%v]", err)
+	}
+
+	// bound to an IP and a user
+	bound, _ := signer.Sign("/delete?file=a.jpg", time.Hour, URLBinding{IP: "10.0.0.1", User: "alice"})
+	var list = []struct {
+		ip, user string
+		err      error
+	}{
+		{"10.0.0.1", "alice", nil},
+		{"10.0.0.2", "alice", ErrBadSignature},
+		{"10.0.0.1", "bob", ErrBadSignature},
+		{"10.0.0.1", "", ErrBadSignature},
+	}
+	for _, l := range list {
+		if err := signer.Verify(request(bound, l.ip, l.user)); err != l.err {
+			t.Errorf("URLSigner.Verify(%s, %s): Returned[%v]. Expected[%v]", l.ip, l.user, err, l.err)
+		}
+	}
+
+	// key rotation, old links keep working until the old key is removed
+	signer.SetKeys(newKey, oldKey)
+	newLink, _ := signer.Sign("/download?file=a.jpg", time.Hour, URLBinding{})
+	if !strings.Contains(newLink, "kid=2026") {
+		t.Errorf("URLSigner.Sign(): Not signed with the first key! [%s]", newLink)
+	}
+	if err := signer.Verify(request(link, "10.0.0.1", "")); err != nil {
+		t.Errorf("URLSigner.Verify(): An old link should still work! [%v]", err)
+	}
+	signer.SetKeys(newKey)
+	if err := signer.Verify(request(link, "10.0.0.1", "")); err != ErrBadSignature {
+		t.Errorf("URLSigner.Verify(): A link of a removed key should return ErrBadSignature! [%v]", err)
+	}
+	if err := signer.Verify(request(newLink, "10.0.0.1", "")); err != nil {
+		t.Errorf("URLSigner.Verify(): Returned an error! [%v]", err)
+	}
+
+	if _, err := NewURLSigner().Sign("/download", time.Hour, URLBinding{}); err != ErrNoSigningKey {
+		t.Errorf("URLSigner.Sign(): Should return ErrNoSigningKey! [%v]", err)
+	}
+}
+
+func TestURLSigner_Middleware(t *testing.T) {
+	signer := NewURLSigner(SigningKey{"1", []byte("a secret that is long enough for a test")})
+	handler := signer.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	fi := &FileInfo{Url: "/download?file=a.jpg", DeleteUrl: "/delete?file=a.jpg", DeleteNoJSUrl: "/delete?file=a.jpg&_method=DELETE"}
+	if err := signer.SignFileInfo(fi, time.Hour, URLBinding{}); err != nil {
+		t.Fatalf("URLSigner.SignFileInfo(): Returned an error! [%s]", err)
+	}
+
+	for _, link := range []string{fi.Url, fi.DeleteUrl} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", link, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("URLSigner.Middleware(%s): Returned[%d]", link, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/download?file=a.jpg", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("URLSigner.Middleware(): An unsigned URL returned[%d]. Expected 403", w.Code)
+	}
+
+	// a name in the body of a POST can not replace the signed name
+	var deleted []string
+	deleteHandler := signer.Middleware(DeleteHandler(func(name string) error {
+		deleted = append(deleted, name)
+		return nil
+	}))
+	r := httptest.NewRequest("POST", fi.DeleteNoJSUrl, strings.NewReader("file=victim.txt&_method=DELETE"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	deleteHandler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || len(deleted) != 1 || deleted[0] != "a.jpg" {
+		t.Errorf("URLSigner.Middleware(DeleteHandler): Returned[%d] and deleted %v. Expected [a.jpg]", w.Code, deleted)
+	}
+}
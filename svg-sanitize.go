@@ -0,0 +1,194 @@
+package fileupload
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors" // external dependency
+)
+
+const (
+	svgNamespace   = "http://www.w3.org/2000/svg"
+	xlinkNamespace = "http://www.w3.org/1999/xlink"
+)
+
+var ErrNotSVG = errors.New("This file is not an SVG image!")
+
+// static SVG elements, everything else is removed together with its children (script, foreignObject, style, animate, ...)
+var svgAllowedElements = []string{
+	"svg", "g", "defs", "title", "desc", "symbol", "use", "switch",
+	"path", "rect", "circle", "ellipse", "line", "polyline", "polygon",
+	"text", "tspan", "textPath",
+	"linearGradient", "radialGradient", "stop", "clipPath", "mask", "pattern", "marker", "image",
+	"filter", "feBlend", "feColorMatrix", "feComponentTransfer", "feComposite", "feConvolveMatrix", "feDiffuseLighting",
+	"feDisplacementMap", "feDistantLight", "feDropShadow", "feFlood", "feFuncA", "feFuncB", "feFuncG", "feFuncR",
+	"feGaussianBlur", "feMerge", "feMergeNode", "feMorphology", "feOffset", "fePointLight", "feSpecularLighting",
+	"feSpotLight", "feTile", "feTurbulence",
+}
+
+// geometry and presentation attributes, event handlers (on*) are never on the list
+var svgAllowedAttributes = []string{
+	"id", "class", "style", "transform", "lang", "version", "baseProfile",
+	"width", "height", "x", "y", "x1", "y1", "x2", "y2", "cx", "cy", "r", "rx", "ry", "fx", "fy", "fr", "d", "points", "pathLength",
+	"viewBox", "preserveAspectRatio", "dx", "dy", "rotate", "textLength", "lengthAdjust", "startOffset", "method", "spacing", "side",
+	"fill", "fill-opacity", "fill-rule", "stroke", "stroke-width", "stroke-opacity", "stroke-linecap", "stroke-linejoin",
+	"stroke-miterlimit", "stroke-dasharray", "stroke-dashoffset", "opacity", "color", "display", "visibility", "overflow",
+	"clip-path", "clip-rule", "clipPathUnits", "mask", "maskUnits", "maskContentUnits", "filter", "filterUnits", "primitiveUnits",
+	"marker-start", "marker-mid", "marker-end", "markerWidth", "markerHeight", "markerUnits", "refX", "refY", "orient",
+	"gradientUnits", "gradientTransform", "spreadMethod", "offset", "stop-color", "stop-opacity",
+	"patternUnits", "patternContentUnits", "patternTransform",
+	"font-family", "font-size", "font-style", "font-weight", "font-variant", "text-anchor", "text-decoration",
+	"dominant-baseline", "alignment-baseline", "baseline-shift", "letter-spacing", "word-spacing", "writing-mode",
+	"color-interpolation", "color-interpolation-filters", "flood-color", "flood-opacity", "lighting-color",
+	"shape-rendering", "text-rendering", "image-rendering", "vector-effect", "paint-order", "mix-blend-mode", "isolation",
+	"in", "in2", "result", "mode", "type", "values", "operator", "k1", "k2", "k3", "k4", "stdDeviation", "edgeMode",
+	"order", "kernelMatrix", "divisor", "bias", "targetX", "targetY", "preserveAlpha", "scale", "xChannelSelector",
+	"yChannelSelector", "radius", "baseFrequency", "numOctaves", "seed", "stitchTiles", "tableValues", "slope",
+	"intercept", "amplitude", "exponent", "surfaceScale", "diffuseConstant", "specularConstant", "specularExponent",
+	"kernelUnitLength", "azimuth", "elevation", "z", "pointsAtX", "pointsAtY", "pointsAtZ", "limitingConeAngle",
+	"href", // checked by svgSafeReference()
+}
+
+var svgDataImageRegexp = regexp.MustCompile(`^data:image/(png|jpeg|gif|webp);base64,[A-Za-z0-9+/=\s]*$`)
+var svgURLRegexp = regexp.MustCompile(`(?i)url\s*\(\s*['"]?\s*([^'")\s]*)`)
+var svgUnsafeCSSRegexp = regexp.MustCompile(`(?i)(@import|expression\s*\(|javascript:|behavior\s*:|-moz-binding)`)
+
+/*
+	Remove active content from an SVG image: scripts, event handlers, foreignObject, style sheets and references to other files or websites
+	The document is parsed and rebuilt from allowed elements and attributes only, comments, processing instructions and the doctype are dropped
+	Returns the cleaned document and the number of removed elements and attributes
+*/
+func sanitizeSVG(buffer []byte) ([]byte, int, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(buffer))
+	decoder.Strict = true // unknown entities are an error, they are never expanded
+
+	out := &bytes.Buffer{}
+	encoder := xml.NewEncoder(out)
+
+	var removed, skipDepth, depth int
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, errors.Wrap(err, "sanitizeSVG()")
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if skipDepth > 0 { // inside a removed element
+				continue
+			}
+			if depth == 1 && (t.Name.Local != "svg" || (t.Name.Space != svgNamespace && t.Name.Space != "")) {
+				return nil, 0, ErrNotSVG
+			}
+			if (t.Name.Space != svgNamespace && t.Name.Space != "") || !inSlice(svgAllowedElements, t.Name.Local) {
+				skipDepth = depth
+				removed++
+				continue
+			}
+
+			element := xml.StartElement{Name: xml.Name{Local: t.Name.Local}}
+			if depth == 1 { // namespaces are only declared once, on the root element
+				element.Attr = append(element.Attr, xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: svgNamespace}, xml.Attr{Name: xml.Name{Local: "xmlns:xlink"}, Value: xlinkNamespace})
+			}
+			for _, attr := range t.Attr {
+				if a, ok := sanitizeSVGAttribute(t.Name.Local, attr); ok {
+					element.Attr = append(element.Attr, a)
+				} else if attr.Name.Space != "xmlns" && attr.Name.Local != "xmlns" {
+					removed++
+				}
+			}
+			if err := encoder.EncodeToken(element); err != nil {
+				return nil, 0, errors.Wrap(err, "sanitizeSVG()")
+			}
+		case xml.EndElement:
+			depth--
+			if skipDepth > 0 {
+				if depth < skipDepth {
+					skipDepth = 0
+				}
+				continue
+			}
+			if err := encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: t.Name.Local}}); err != nil {
+				return nil, 0, errors.Wrap(err, "sanitizeSVG()")
+			}
+		case xml.CharData:
+			if skipDepth > 0 || depth == 0 {
+				continue
+			}
+			if err := encoder.EncodeToken(t); err != nil {
+				return nil, 0, errors.Wrap(err, "sanitizeSVG()")
+			}
+		case xml.Comment, xml.ProcInst, xml.Directive: // dropped, a doctype could declare entities
+		}
+	}
+
+	if err := encoder.Flush(); err != nil {
+		return nil, 0, errors.Wrap(err, "sanitizeSVG()")
+	}
+	if out.Len() == 0 {
+		return nil, 0, ErrNotSVG
+	}
+
+	return out.Bytes(), removed, nil
+}
+
+// returns the attribute to keep without a namespace, false when it has to be removed
+func sanitizeSVGAttribute(element string, attr xml.Attr) (xml.Attr, bool) {
+	name := attr.Name.Local
+	switch attr.Name.Space {
+	case "", svgNamespace:
+	case xlinkNamespace, "xlink":
+		if name != "href" {
+			return attr, false
+		}
+		name = "xlink:href"
+	case "http://www.w3.org/XML/1998/namespace", "xml":
+		if name != "space" && name != "lang" {
+			return attr, false
+		}
+		name = "xml:" + name
+	default:
+		return attr, false
+	}
+
+	if !inSlice(svgAllowedAttributes, attr.Name.Local) && name != "xml:space" && name != "xml:lang" {
+		return attr, false
+	}
+	if strings.HasPrefix(strings.ToLower(attr.Name.Local), "on") { // event handlers, never allowed
+		return attr, false
+	}
+
+	value := attr.Value
+	if attr.Name.Local == "href" && !svgSafeReference(element, value) {
+		return attr, false
+	}
+	if strings.Contains(value, `\`) { // a CSS escape hides url( and expression( from the checks below, "u\72l(" is url(
+		return attr, false
+	}
+	for _, match := range svgURLRegexp.FindAllStringSubmatch(value, -1) { // fill="url(#gradient)" is fine, url(http://...) is not
+		if !strings.HasPrefix(match[1], "#") {
+			return attr, false
+		}
+	}
+	if svgUnsafeCSSRegexp.MatchString(value) {
+		return attr, false
+	}
+
+	return xml.Attr{Name: xml.Name{Local: name}, Value: value}, true
+}
+
+// only references inside the document are allowed, and embedded raster images for <image>
+func svgSafeReference(element, value string) bool {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "#") {
+		return true
+	}
+	return element == "image" && svgDataImageRegexp.MatchString(value)
+}
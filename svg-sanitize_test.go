@@ -0,0 +1,65 @@
+package fileupload
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_sanitizeSVG(t *testing.T) {
+	dirty := `<?xml version="1.0"?>
+<!DOCTYPE svg [<!ENTITY x "y">]>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="100" height="100" onload="alert(1)">
+	<!-- comment -->
+	<script>alert(document.cookie)</script>
+	<style>@import url(http://evil.example/x.css);</style>
+	<defs><linearGradient id="g"><stop offset="0" stop-color="red"/></linearGradient></defs>
+	<rect width="10" height="10" fill="url(#g)" onclick="steal()"/>
+	<circle r="5" fill="url(http://evil.example/track.svg#x)"/>
+	<use xlink:href="#g"/>
+	<use href="http://evil.example/sprite.svg#icon"/>
+	<image href="file:///etc/passwd"/>
+	<image href="data:image/png;base64,iVBORw0KGgo="/>
+	<a href="javascript:alert(1)"><text>click</text></a>
+	<foreignObject><div xmlns="http://www.w3.org/1999/xhtml">html</div></foreignObject>
+	<text x="1" y="2" style="font-size:12px">Hello &amp; goodbye</text>
+	<path d="M0 0L10 10" style="behavior:url(x.htc)"/>
+	<rect width="1" height="1" style="fill:u\72l(http://evil.example/escaped.svg#x)"/>
+	<rect width="1" height="1" fill="\75rl(http://evil.example/escaped.svg#x)"/>
+	<rect width="1" height="1" style="fill:u&#92;72l(http://evil.example/entity.svg#x)"/>
+</svg>`
+
+	clean, removed, err := sanitizeSVG([]byte(dirty))
+	if err != nil {
+		t.Fatalf("sanitizeSVG(): Returned an error! [%s]", err)
+	}
+
+	for _, s := range []string{"script", "alert", "onload", "onclick", "@import", "evil.example", "file://", "javascript", "foreignObject", "<a", "behavior", "<!--", "DOCTYPE"} {
+		if strings.Contains(string(clean), s) {
+			t.Errorf("sanitizeSVG(): Cleaned document contains %q! [%s]", s, clean)
+		}
+	}
+	for _, s := range []string{`<svg xmlns="http://www.w3.org/2000/svg"`, `fill="url(#g)"`, `xlink:href="#g"`, `href="data:image/png;base64,iVBORw0KGgo="`, `Hello &amp; goodbye`, `style="font-size:12px"`, `<stop offset="0" stop-color="red"></stop>`} {
+		if !strings.Contains(string(clean), s) {
+			t.Errorf("sanitizeSVG(): Cleaned document is missing %q! [%s]", s, clean)
+		}
+	}
+	if removed != 13 {
+		t.Errorf("sanitizeSVG(): Removed[%d]. Expected: 13", removed)
+	}
+
+	// the cleaned document is still an SVG image
+	if _, _, err := readSVGHeader(strings.NewReader(string(clean))); err != nil {
+		t.Errorf("readSVGHeader(): Cleaned document can not be read! [%s]", err)
+	}
+
+	var list = []string{
+		`<html><svg></svg></html>`,
+		`<svg xmlns="http://www.w3.org/2000/svg"><text>&xxe;</text></svg>`, // unknown entities are never expanded
+		`not xml at all`,
+	}
+	for _, l := range list {
+		if _, _, err := sanitizeSVG([]byte(l)); err == nil {
+			t.Errorf("sanitizeSVG(%s): Should return an error!", l)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package fileupload
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+
+	"github.com/pkg/errors" // external dependency
+)
+
+/*
+	Upload an SVG image, only the sanitized document is saved, see sanitizeSVG()
+	Returns ErrNotSVG for other files
+*/
+func UploadSVG(header *multipart.FileHeader, directory string) (*FileInfo, error) {
+	return UploadSVGWithOptions(header, directory, UploadOptions{})
+}
+
+/*
+	Same as UploadSVG(), see UploadOptions. A generated name always ends in ".svg", KeepExtension is not used
+	options.Validate is called once the file is known to be an SVG image
+*/
+func UploadSVGWithOptions(header *multipart.FileHeader, directory string, options UploadOptions) (*FileInfo, error) {
+	if _, err := os.Stat(directory); os.IsNotExist(err) { // does the directory exist?
+		return nil, ErrDirectoryDoesNotExist
+	}
+
+	validate := options.Validate
+	options.Validate = func(header *multipart.FileHeader, mimetype string) error {
+		if mimetype != "image/svg+xml" {
+			return ErrNotSVG
+		}
+		if validate != nil {
+			return validate(header, mimetype)
+		}
+		return nil
+	}
+	options.extension = "svg"
+
+	return uploadFile(header, options, func(mimetype string) (string, error) {
+		return directory, nil
+	}, nil)
+}
+
+/*
+	Sanitize an uploaded SVG image and save the cleaned document
+	Used by UploadSVG(), UploadFile() and UploadFileByCategory(), an SVG file is never saved as it was uploaded
+*/
+func saveSVG(file io.Reader, directory, newName, oldName string, generated bool) (*FileInfo, error) {
+	// read one byte more than allowed to find files that are too large
+	var reader io.Reader = file
+	if DefaultImageLimits.MaxBytes > 0 {
+		reader = io.LimitReader(file, DefaultImageLimits.MaxBytes+1)
+	}
+	buffer, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "saveSVG()")
+	}
+	if err := DefaultImageLimits.check(int64(len(buffer)), nil); err != nil {
+		return nil, err
+	}
+
+	clean, _, err := sanitizeSVG(buffer)
+	if err == ErrNotSVG { // a file named .svg that is something else
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "saveSVG()")
+	}
+	width, height, err := readSVGHeader(bytes.NewReader(clean))
+	if errors.Cause(err) == ErrInvalidImageSize {
+		return nil, ErrInvalidImageSize
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "saveSVG()")
+	}
+	if err := DefaultImageLimits.check(int64(len(clean)), &imageHeader{Format: "svg", Width: width, Height: height, Frames: 1}); err != nil {
+		return nil, err
+	}
+
+	name, _, err := writeUploadedFile(directory, newName, generated, bytes.NewReader(clean))
+	if infected, ok := err.(*InfectedError); ok {
+		return infectedFileInfo(infected, newName, oldName, "image/svg+xml"), infected
+	}
+	if err == ErrFileExists {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "saveSVG()")
+	}
+
+	return &FileInfo{Name: name, OriginalName: oldName, Size: int64(len(clean)), IsImage: true, Directory: directory, MimeType: "image/svg+xml", Width: width, Height: height, Sanitized: true}, nil
+}
@@ -0,0 +1,122 @@
+package fileupload
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+const dirtySVG = `<svg xmlns="http://www.w3.org/2000/svg" width="64" height="32" onload="alert(1)"><script>alert(2)</script><rect width="10" height="10"/></svg>`
+
+func Test_UploadSVG(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testing-filevalidator") // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	req := setupRequestMultipartForm(&testFile{base64.StdEncoding.EncodeToString([]byte(dirtySVG)), "svgupload", "logo.svg"}, &testFile{gopherPNG, "pngupload", "gopher.png"})
+
+	fi, err := UploadSVG(req.MultipartForm.File["svgupload"][0], dir)
+	if err != nil {
+		t.Fatalf("UploadSVG(): Returned an error! [%s]", err)
+	}
+	if !fi.Sanitized || fi.MimeType != "image/svg+xml" || fi.Width != 64 || fi.Height != 32 || !strings.HasSuffix(fi.Name, ".svg") {
+		t.Errorf("UploadSVG(): Returned wrong data! [%+v]", *fi)
+	}
+
+	saved, err := ioutil.ReadFile(dir + string(os.PathSeparator) + fi.Name)
+	if err != nil { // check if the file exists
+		t.Fatalf("UploadSVG(): File failed to upload! [%s]", err)
+	}
+	if strings.Contains(string(saved), "alert") || int64(len(saved)) != fi.Size {
+		t.Errorf("UploadSVG(): The saved file was not sanitized! [%s]", saved)
+	}
+
+	// the URLs are set like for other uploads
+	fi, err = UploadSVGWithOptions(req.MultipartForm.File["svgupload"][0], dir, UploadOptions{KeepExtension: true, SetURLs: func(fi *FileInfo) { fi.Url = "/svg/" + fi.Name }})
+	if err != nil || fi.Url != "/svg/"+fi.Name || !strings.HasSuffix(fi.Name, ".svg") {
+		t.Errorf("UploadSVGWithOptions(): Returned[%+v %v]", fi, err)
+	}
+
+	if _, err := UploadSVG(req.MultipartForm.File["pngupload"][0], dir); err != ErrNotSVG {
+		t.Errorf("UploadSVG(gopher.png): Should return ErrNotSVG! Returned[%v]", err)
+	}
+
+	// the size of an SVG image is checked against DefaultImageLimits
+	huge := setupRequestMultipartForm(&testFile{base64.StdEncoding.EncodeToString([]byte(`<svg xmlns="http://www.w3.org/2000/svg" width="1e300" height="1"></svg>`)), "svgupload", "huge.svg"})
+	if _, err := UploadSVG(huge.MultipartForm.File["svgupload"][0], dir); err != ErrInvalidImageSize {
+		t.Errorf("UploadSVG(1e300): Returned[%v]. Expected[%s]", err, ErrInvalidImageSize)
+	}
+	large := setupRequestMultipartForm(&testFile{base64.StdEncoding.EncodeToString([]byte(`<svg xmlns="http://www.w3.org/2000/svg" width="100000" height="100000"></svg>`)), "svgupload", "large.svg"})
+	if _, err := UploadSVG(large.MultipartForm.File["svgupload"][0], dir); err == nil {
+		t.Errorf("UploadSVG(100000x100000): Should return an *ImageLimitError!")
+	} else if _, ok := err.(*ImageLimitError); !ok {
+		t.Errorf("UploadSVG(100000x100000): Should return an *ImageLimitError! Returned[%v]", err)
+	}
+}
+
+func TestUploadFile_svg(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testing-filevalidator") // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	req := setupRequestMultipartForm(&testFile{base64.StdEncoding.EncodeToString([]byte(dirtySVG)), "svgupload", "logo.svg"})
+	header := req.MultipartForm.File["svgupload"][0]
+
+	fis := make([]*FileInfo, 2)
+	if fis[0], err = UploadFile(header, dir, true); err != nil {
+		t.Fatalf("UploadFile(): Returned an error! [%s]", err)
+	}
+	if fis[1], err = UploadFileByCategory(header, CatSlice(&Category{[]string{"image/svg+xml"}, dir}), true); err != nil {
+		t.Fatalf("UploadFileByCategory(): Returned an error! [%s]", err)
+	}
+
+	for _, fi := range fis {
+		saved, err := ioutil.ReadFile(dir + string(os.PathSeparator) + fi.Name)
+		if err != nil { // check if the file exists
+			t.Errorf("UploadFile(): File failed to upload! [%s]", err)
+			continue
+		}
+		if !fi.Sanitized || strings.Contains(string(saved), "alert") {
+			t.Errorf("UploadFile(): The saved file was not sanitized! [%s]", saved)
+		}
+	}
+}
+
+func TestUploadFile_svgDetection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testing-filevalidator") // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	// documents that a byte prefix does not find
+	var list = []string{
+		"<!-- " + strings.Repeat("x", 1000) + ` --><svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`,
+		`<!DOCTYPE svg [ <!ELEMENT svg ANY> ]><svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`,
+		`<svg:svg xmlns:svg="http://www.w3.org/2000/svg"><svg:script>alert(1)</svg:script></svg:svg>`,
+	}
+	for _, document := range list {
+		req := setupRequestMultipartForm(&testFile{base64.StdEncoding.EncodeToString([]byte(document)), "svgupload", "logo.txt"})
+		fi, err := UploadFile(req.MultipartForm.File["svgupload"][0], dir, false)
+		if err != nil {
+			t.Errorf("UploadFile(%.40q): Returned an error! [%s]", document, err)
+			continue
+		}
+		saved, err := ioutil.ReadFile(dir + string(os.PathSeparator) + fi.Name)
+		if err != nil || !fi.Sanitized || fi.MimeType != "image/svg+xml" || strings.Contains(string(saved), "alert") {
+			t.Errorf("UploadFile(%.40q): The saved file was not sanitized! [%s %v]", document, saved, err)
+		}
+	}
+
+	// a file named .svg is always sanitized, or refused when it is not an SVG image
+	req := setupRequestMultipartForm(&testFile{base64.StdEncoding.EncodeToString([]byte("<html><script>alert(1)</script></html>")), "svgupload", "logo.svg"})
+	if _, err := UploadFile(req.MultipartForm.File["svgupload"][0], dir, true); err != ErrNotSVG {
+		t.Errorf("UploadFile(logo.svg): Should return ErrNotSVG! Returned[%v]", err)
+	}
+}
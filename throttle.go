@@ -0,0 +1,281 @@
+package fileupload
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// bytes read at once by a throttled reader, smaller reads keep the rate smooth
+const throttleChunk = 32 << 10
+
+// per client limits unused for this long are forgotten
+const throttleIdle = 10 * time.Minute
+
+/*
+	A token bucket of bytes, safe for concurrent use
+	bytesPerSecond - 0 is no limit
+	burst - bytes that can be read at once after a pause, 0 is one second of bytesPerSecond
+*/
+type RateLimit struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimit(bytesPerSecond, burst int64) *RateLimit {
+	l := &RateLimit{}
+	l.SetRate(bytesPerSecond, burst)
+	return l
+}
+
+// change the limit, readers that are already throttled use it from their next read
+func (l *RateLimit) SetRate(bytesPerSecond, burst int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if burst <= 0 {
+		burst = bytesPerSecond
+	}
+	l.rate, l.burst = float64(bytesPerSecond), float64(burst)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// take n bytes from the bucket, returns how long to wait before using them. The bucket goes into debt for later callers
+func (l *RateLimit) reserve(n int) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+	} else {
+		l.tokens = l.burst
+	}
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+/*
+	Bandwidth limits for uploads and downloads, each can be changed while files are copied
+	A copy is limited by the global limit, the limit of its client key and its own per upload limit, the slowest one applies
+	Example:
+	throttle := fileupload.NewThrottle()
+	throttle.SetGlobal(50<<20, 0)   // 50 MB/s for the whole server
+	throttle.SetPerClient(5<<20, 0) // 5 MB/s for each IP
+	throttle.SetClient("10.0.0.7", 512<<10, 0)
+	fileupload.DefaultThrottle = throttle
+	http.Handle("/upload", throttle.Middleware(uploadHandler))
+*/
+type Throttle struct {
+	KeyFunc func(r *http.Request) string // the client key of a request, the IP address when nil
+
+	global *RateLimit
+
+	mu               sync.Mutex
+	uploadRate       int64
+	uploadBurst      int64
+	clientRate       int64
+	clientBurst      int64
+	clients          map[string]*clientLimit // per client limits made from clientRate
+	overrides        map[string]*RateLimit   // limits set for one client with SetClient()
+	lastClientPurged time.Time
+}
+
+type clientLimit struct {
+	limit    *RateLimit
+	lastUsed time.Time
+}
+
+/*
+	Used for uploads (UploadOptions.ClientKey) and by DownloadHandler() when it is not nil
+	Uploads are only limited once they are parsed, use Throttle.Middleware() to limit the request body as it arrives
+	Uploads with the Context of a request limited by the Middleware are not limited again, see UploadOptions.Context
+*/
+var DefaultThrottle *Throttle
+
+// a throttle without limits
+func NewThrottle() *Throttle {
+	return &Throttle{global: NewRateLimit(0, 0), clients: map[string]*clientLimit{}, overrides: map[string]*RateLimit{}}
+}
+
+// the limit of all copies together, 0 is no limit
+func (t *Throttle) SetGlobal(bytesPerSecond, burst int64) {
+	t.global.SetRate(bytesPerSecond, burst)
+}
+
+// the limit of each copy, for copies started afterwards. 0 is no limit
+func (t *Throttle) SetPerUpload(bytesPerSecond, burst int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.uploadRate, t.uploadBurst = bytesPerSecond, burst
+}
+
+// the limit of each client key, also for clients that are copying. 0 is no limit
+func (t *Throttle) SetPerClient(bytesPerSecond, burst int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clientRate, t.clientBurst = bytesPerSecond, burst
+	for _, client := range t.clients {
+		client.limit.SetRate(bytesPerSecond, burst)
+	}
+}
+
+// a limit for one client instead of the per client limit, a negative bytesPerSecond removes it
+func (t *Throttle) SetClient(key string, bytesPerSecond, burst int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if bytesPerSecond < 0 {
+		delete(t.overrides, key)
+		return
+	}
+	if limit, ok := t.overrides[key]; ok {
+		limit.SetRate(bytesPerSecond, burst)
+		return
+	}
+	t.overrides[key] = NewRateLimit(bytesPerSecond, burst)
+}
+
+// the limits of one copy
+func (t *Throttle) limits(clientKey string) []*RateLimit {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	limits := []*RateLimit{t.global}
+	if t.uploadRate > 0 {
+		limits = append(limits, NewRateLimit(t.uploadRate, t.uploadBurst))
+	}
+	if len(clientKey) == 0 {
+		return limits
+	}
+	if limit, ok := t.overrides[clientKey]; ok {
+		return append(limits, limit)
+	}
+
+	now := time.Now()
+	if now.Sub(t.lastClientPurged) > throttleIdle {
+		for key, client := range t.clients {
+			if now.Sub(client.lastUsed) > throttleIdle {
+				delete(t.clients, key)
+			}
+		}
+		t.lastClientPurged = now
+	}
+	client, ok := t.clients[clientKey]
+	if !ok {
+		client = &clientLimit{limit: NewRateLimit(t.clientRate, t.clientBurst)}
+		t.clients[clientKey] = client
+	}
+	client.lastUsed = now
+	return append(limits, client.limit)
+}
+
+// limit the bytes read from r, clientKey can be empty
+func (t *Throttle) Reader(clientKey string, r io.Reader) io.Reader {
+	return t.ReaderContext(context.Background(), clientKey, r)
+}
+
+// same as Reader(), a read that waits for the limit returns ctx.Err() once ctx is done
+func (t *Throttle) ReaderContext(ctx context.Context, clientKey string, r io.Reader) io.Reader {
+	return &throttledReader{ctx: ctx, r: r, limits: t.limits(clientKey)}
+}
+
+// the client key of a request, see KeyFunc
+func (t *Throttle) clientKey(r *http.Request) string {
+	if t.KeyFunc != nil {
+		return t.KeyFunc(r)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// the context key of requests limited by Throttle.Middleware()
+type throttledKey struct{}
+
+/*
+	Limit the request body while the next handler reads it, for example with ParseMultipartForm()
+	The context of the request is marked, uploads given this context (UploadOptions.Context) are not limited a second time
+*/
+func (t *Throttle) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			r = r.WithContext(context.WithValue(r.Context(), throttledKey{}, true))
+			r.Body = wrappedBody{t.ReaderContext(r.Context(), t.clientKey(r), r.Body), r.Body}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limit an upload with DefaultThrottle, unless the body of its request was already limited by Throttle.Middleware(). ctx can be nil
+func throttleUpload(ctx context.Context, clientKey string, r io.Reader) io.Reader {
+	if DefaultThrottle == nil {
+		return r
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if ctx.Value(throttledKey{}) != nil {
+		return r
+	}
+	return DefaultThrottle.ReaderContext(ctx, clientKey, r)
+}
+
+type throttledReader struct {
+	ctx    context.Context
+	r      io.Reader
+	limits []*RateLimit
+}
+
+func (tr *throttledReader) Read(b []byte) (int, error) {
+	if len(b) > throttleChunk {
+		b = b[:throttleChunk]
+	}
+	n, err := tr.r.Read(b)
+	if n > 0 {
+		var wait time.Duration
+		for _, limit := range tr.limits {
+			if d := limit.reserve(n); d > wait {
+				wait = d
+			}
+		}
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-tr.ctx.Done(): // the client went away, stop waiting for it
+				timer.Stop()
+				return n, tr.ctx.Err()
+			}
+		}
+	}
+	return n, err
+}
+
+// a throttled file for http.ServeContent(), seeking is not limited
+type throttledReadSeeker struct {
+	io.Reader
+	io.Seeker
+}
@@ -0,0 +1,153 @@
+package fileupload
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// how long it takes to read size bytes through a throttle
+func timeThrottledRead(t *testing.T, throttle *Throttle, clientKey string, size int) time.Duration {
+	start := time.Now()
+	n, err := ioutil.ReadAll(throttle.Reader(clientKey, bytes.NewReader(make([]byte, size))))
+	if err != nil || len(n) != size {
+		t.Fatalf("Throttle.Reader(): Read[%d %v]. Expected %d bytes", len(n), err, size)
+	}
+	return time.Since(start)
+}
+
+func TestThrottle(t *testing.T) {
+	var list = []struct {
+		setup    func(throttle *Throttle)
+		key      string
+		min, max time.Duration
+	}{
+		{func(throttle *Throttle) {}, "", 0, 100 * time.Millisecond},
+		// 10000 bytes at once, the other 50000 bytes at 100000 bytes a second
+		{func(throttle *Throttle) { throttle.SetGlobal(100000, 10000) }, "", 400 * time.Millisecond, 900 * time.Millisecond},
+		{func(throttle *Throttle) { throttle.SetPerUpload(100000, 10000) }, "a", 400 * time.Millisecond, 900 * time.Millisecond},
+		{func(throttle *Throttle) { throttle.SetPerClient(100000, 10000) }, "a", 400 * time.Millisecond, 900 * time.Millisecond},
+		{func(throttle *Throttle) { throttle.SetPerClient(100000, 10000) }, "", 0, 100 * time.Millisecond},
+		// the limit of one client replaces the per client limit, the slowest limit applies
+		{func(throttle *Throttle) { throttle.SetPerClient(1000, 1); throttle.SetClient("a", 100000, 10000) }, "a", 400 * time.Millisecond, 900 * time.Millisecond},
+		{func(throttle *Throttle) { throttle.SetGlobal(100000, 10000); throttle.SetClient("a", 1<<30, 0) }, "a", 400 * time.Millisecond, 900 * time.Millisecond},
+	}
+	for i, l := range list {
+		throttle := NewThrottle()
+		l.setup(throttle)
+		if d := timeThrottledRead(t, throttle, l.key, 60000); d < l.min || d > l.max {
+			t.Errorf("Throttle[%d]: Reading took[%s]. Expected between %s and %s", i, d, l.min, l.max)
+		}
+	}
+
+	// limits can be changed while clients are copying
+	throttle := NewThrottle()
+	throttle.SetPerClient(1000, 1)
+	reader := throttle.Reader("a", bytes.NewReader(make([]byte, 60000)))
+	throttle.SetPerClient(0, 0)
+	start := time.Now()
+	ioutil.ReadAll(reader)
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("Throttle.SetPerClient(): The removed limit still applies! [%s]", d)
+	}
+
+	// a read waiting for the limit stops when the context is done
+	throttle = NewThrottle()
+	throttle.SetGlobal(1000, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start = time.Now()
+	if _, err := ioutil.ReadAll(throttle.ReaderContext(ctx, "", bytes.NewReader(make([]byte, 60000)))); err != context.Canceled {
+		t.Errorf("Throttle.ReaderContext(): Returned[%v]. Expected[%s]", err, context.Canceled)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Throttle.ReaderContext(): Kept waiting after the context was done [%s]", d)
+	}
+}
+
+func TestThrottle_Middleware(t *testing.T) {
+	tempDir := "testing-filevalidator"
+	dir, err := ioutil.TempDir("", tempDir) // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	// 10000 bytes at once, the other 50000 bytes take half a second
+	DefaultThrottle = NewThrottle()
+	DefaultThrottle.SetPerClient(100000, 10000)
+	defer func() { DefaultThrottle = nil }()
+	data := base64.StdEncoding.EncodeToString(make([]byte, 60000))
+
+	// the body is limited by the Middleware, the upload of the same request is not limited again
+	handler := DefaultThrottle.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			t.Fatalf("ParseMultipartForm(): Returned an error! [%s]", err)
+		}
+		options := UploadOptions{ClientKey: DefaultThrottle.clientKey(r), Context: r.Context(), Hash: true}
+		if _, err := UploadFileWithOptions(r.MultipartForm.File["fileupload"][0], dir, options); err != nil {
+			t.Errorf("UploadFileWithOptions(): Returned an error! [%s]", err)
+		}
+	}))
+	body, contentType := setupHTTPRequestBody(&testFile{data, "fileupload", "zeros.bin"})
+	r := httptest.NewRequest("POST", "/upload", body)
+	r.Header.Set("Content-Type", contentType)
+	start := time.Now()
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if d := time.Since(start); d < 400*time.Millisecond || d > 900*time.Millisecond {
+		t.Errorf("Throttle.Middleware(): The upload took[%s]. Expected about 500ms, the bytes are only counted once", d)
+	}
+
+	// without the Middleware the upload itself is limited, also when it is hashed
+	time.Sleep(200 * time.Millisecond) // the bucket of the client fills up again
+	header := setupRequestMultipartForm(&testFile{data, "fileupload", "zeros.bin"}).MultipartForm.File["fileupload"][0]
+	start = time.Now()
+	if _, err := UploadFileWithOptions(header, dir, UploadOptions{ClientKey: "192.0.2.1", Hash: true}); err != nil {
+		t.Errorf("UploadFileWithOptions(): Returned an error! [%s]", err)
+	}
+	if d := time.Since(start); d < 400*time.Millisecond || d > 900*time.Millisecond {
+		t.Errorf("UploadFileWithOptions(Hash): The upload took[%s]. Expected about 500ms", d)
+	}
+}
+
+func TestDownloadHandler_throttle(t *testing.T) {
+	tempDir := "testing-filevalidator"
+	dir, err := ioutil.TempDir("", tempDir) // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	png, _ := base64.StdEncoding.DecodeString(gopherPNG)
+	if err := ioutil.WriteFile(dir+string(os.PathSeparator)+"gopher.png", png, 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile: %s", err)
+	}
+
+	DefaultThrottle = NewThrottle()
+	DefaultThrottle.SetPerClient(int64(len(png))*2, 1) // half a second per download
+	defer func() { DefaultThrottle = nil }()
+
+	handler := DownloadHandler(DirectoryLookup(dir))
+	start := time.Now()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/download?file=gopher.png", nil))
+	if d := time.Since(start); w.Code != http.StatusOK || w.Body.Len() != len(png) || d < 400*time.Millisecond || d > time.Second {
+		t.Errorf("DownloadHandler(): Returned[%d, %d bytes] in %s. Expected %d bytes in about 500ms", w.Code, w.Body.Len(), d, len(png))
+	}
+
+	// another client has its own limit
+	r := httptest.NewRequest("GET", "/download?file=gopher.png", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	DefaultThrottle.SetClient("10.0.0.2", 1<<30, 0)
+	start = time.Now()
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("DownloadHandler(): The limit of the client was not used [%s]", d)
+	}
+}
@@ -0,0 +1,220 @@
+package fileupload
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"mime/multipart"
+	"os"
+
+	"github.com/google/uuid" // external dependencies
+	"github.com/pkg/errors"
+)
+
+var ErrFileExists = errors.New("A file with this name already exists!")
+
+/*
+	Options for UploadFileWithOptions() and UploadFileByCategoryWithOptions(), the zero value saves the file under a UUID without extension
+
+	KeepExtension - add the extension of the uploaded file to the UUID ("uuid.jpg"), the includeOldExtension flag of UploadFile()
+	NameFunc - the name to save a file under instead of a UUID, it is checked like a deleted name and never replaces an existing file (ErrFileExists)
+	Owner - the file counts towards the storage quota of the owner, see DefaultQuota
+	MimeTypes - only these types are saved, others return ErrNoMatchingMimeType. Empty allows all types
+	MaxSize - bytes, larger files return ErrFileTooLarge. 0 is no limit
+	Validate - more checks once the type is known, its error is returned as is and the file is not saved
+	Hash - set FileInfo.Hash to the SHA-256 of the uploaded bytes
+	SetURLs - fill in the URLs of the saved file, DefaultURLBuilder when nil
+	Progress, UploadID - count the copy of the file in the registry, the upload is finished once the file is saved or refused
+	ClientKey - the copy is limited by DefaultThrottle for this client ("10.0.0.7" or a user), empty only applies the global and per upload limits
+	Context - the context of the request, a throttled copy stops when it is done. Not limited again when Throttle.Middleware() limited the request
+*/
+type UploadOptions struct {
+	KeepExtension bool
+	NameFunc      func(originalName, mimetype string) string
+	Owner         string
+	MimeTypes     []string
+	MaxSize       int64
+	Validate      func(header *multipart.FileHeader, mimetype string) error
+	Hash          bool
+	SetURLs       func(fi *FileInfo)
+	Progress      *ProgressRegistry
+	UploadID      string
+	ClientKey     string
+	Context       context.Context
+
+	extension string // set by UploadSVGWithOptions(), generated names end in it instead of the extension of the upload
+}
+
+// copy an uploaded file to a directory, see UploadOptions
+func UploadFileWithOptions(header *multipart.FileHeader, directory string, options UploadOptions) (*FileInfo, error) {
+	if _, err := os.Stat(directory); os.IsNotExist(err) { // does the directory exist?
+		return nil, ErrDirectoryDoesNotExist
+	}
+	return uploadFile(header, options, func(mimetype string) (string, error) {
+		return directory, nil
+	}, nil)
+}
+
+// copy an uploaded file to the directory of its Category, see UploadFileByCategory() and UploadOptions
+func UploadFileByCategoryWithOptions(header *multipart.FileHeader, list []Category, options UploadOptions) (*FileInfo, error) {
+	return uploadFile(header, options, func(mimetype string) (string, error) {
+		return categoryDirectory(list, mimetype)
+	}, nil)
+}
+
+func UploadAllFilesWithOptions(files map[string][]*multipart.FileHeader, directory string, options UploadOptions) ([]FileInfo, error) {
+	var slice []FileInfo
+
+	for _, fieldSlice := range files {
+		for _, header := range fieldSlice {
+			fi, err := UploadFileWithOptions(header, directory, options)
+			if err != nil {
+				return nil, errors.Wrap(err, "UploadAllFilesWithOptions()")
+			}
+			slice = append(slice, *fi)
+		}
+	}
+
+	return slice, nil
+}
+
+func UploadAllFilesByCategoryWithOptions(files map[string][]*multipart.FileHeader, list []Category, options UploadOptions) ([]FileInfo, error) {
+	var slice []FileInfo
+
+	for _, fieldSlice := range files {
+		for _, header := range fieldSlice {
+			fi, err := UploadFileByCategoryWithOptions(header, list, options)
+			if err != nil {
+				return nil, errors.Wrap(err, "UploadAllFilesByCategoryWithOptions()")
+			}
+			slice = append(slice, *fi)
+		}
+	}
+
+	return slice, nil
+}
+
+/*
+	Check and copy an uploaded file, directoryFunc picks the directory once the mimetype is known
+	The hooks of DefaultHooks are called, see BeforeUploadHook
+	saved - nil, or called once the file is saved and before the AfterStore hooks (the state of a Quarantine). Its error removes the file
+*/
+func uploadFile(header *multipart.FileHeader, options UploadOptions, directoryFunc func(mimetype string) (string, error), saved func(fi *FileInfo) error) (fi *FileInfo, err error) {
+	event := &UploadEvent{Header: header, Owner: options.Owner}
+	defer func() {
+		if err != nil {
+			DefaultHooks.onError(event, err)
+		}
+	}()
+
+	if options.MaxSize > 0 && header.Size > options.MaxSize {
+		return nil, ErrFileTooLarge
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return nil, errors.Wrap(err, "uploadFile()")
+	}
+	defer file.Close()
+
+	if event.MimeType, err = getMimeType(file); err != nil {
+		return nil, errors.Wrap(err, "uploadFile()")
+	}
+	if len(options.MimeTypes) > 0 && !inSlice(options.MimeTypes, event.MimeType) {
+		return nil, ErrNoMatchingMimeType
+	}
+	if options.Validate != nil {
+		if err := options.Validate(header, event.MimeType); err != nil {
+			return nil, err
+		}
+	}
+
+	if event.Directory, err = directoryFunc(event.MimeType); err != nil {
+		return nil, err
+	}
+	if event.Name, err = options.newName(event.Directory, header.Filename, event.MimeType); err != nil {
+		return nil, err
+	}
+	newName, directory := event.Name, event.Directory
+	if err := DefaultHooks.beforeUpload(event); err != nil {
+		return nil, err
+	}
+	event.Directory = directory                                   // only the name can be changed
+	generated := options.NameFunc == nil && event.Name == newName // a UUID, it is changed when the name is taken
+	if event.Name != newName {                                    // renamed by a hook
+		if err := checkNewName(event.Directory, event.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	reader := throttleUpload(options.Context, options.ClientKey, file)
+	if options.Progress != nil && len(options.UploadID) > 0 {
+		reader = options.Progress.Reader(options.UploadID, reader, header.Size)
+		defer func() { options.Progress.Finish(options.UploadID, err) }()
+	}
+	var sum hash.Hash
+	if options.Hash {
+		sum = sha256.New()
+		reader = io.TeeReader(reader, sum)
+	}
+
+	fi, err = copyOwnedFile(event.Directory, event.Name, event.MimeType, header.Filename, options.Owner, generated, header.Size, reader)
+	if err != nil {
+		return fi, err
+	}
+	event.Name = fi.Name
+	if sum != nil {
+		fi.Hash = hex.EncodeToString(sum.Sum(nil))
+	}
+	if saved != nil {
+		if err := saved(fi); err != nil {
+			removeFromDirectory(fi.Directory, fi.Name)
+			ReleaseQuota(fi.Directory, fi.Name)
+			return nil, err
+		}
+	}
+	setFileURLs(fi, options.SetURLs)
+	DefaultHooks.afterStore(event, fi)
+
+	return fi, nil
+}
+
+// the name to save an upload under in the directory
+func (o UploadOptions) newName(directory, originalName, mimetype string) (string, error) {
+	if o.NameFunc == nil {
+		newName := uuid.New().String() //UUIDv4
+		if len(o.extension) > 0 {
+			newName += "." + o.extension
+		} else if o.KeepExtension {
+			newName += "." + getFileExtension(originalName)
+		}
+		return newName, nil
+	}
+
+	newName := o.NameFunc(originalName, mimetype)
+	if err := checkNewName(directory, newName); err != nil {
+		return "", err
+	}
+	return newName, nil
+}
+
+/*
+	A name that is not a UUID has to be valid and not taken yet
+	Only an early check, two uploads can pass it with the same name. The file is saved without replacing another, see writeUploadedFile()
+*/
+func checkNewName(directory, name string) error {
+	if err := checkFileName(name); err != nil {
+		return err
+	}
+	root, err := OpenRoot(directory)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	if _, err := root.Lstat(name); err == nil {
+		return ErrFileExists
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package fileupload
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors" // external dependency
+)
+
+func TestUploadFileWithOptions(t *testing.T) {
+	tempDir := "testing-filevalidator"
+	dir, err := ioutil.TempDir("", tempDir) // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	req := setupRequestMultipartForm(&testFile{gopherPNG, "fileupload", "gopher.png"})
+	header := req.MultipartForm.File["fileupload"][0]
+
+	png, _ := base64.StdEncoding.DecodeString(gopherPNG)
+	sum := sha256.Sum256(png)
+	options := UploadOptions{
+		NameFunc: func(originalName, mimetype string) string { return "avatar-" + originalName },
+		Hash:     true,
+		SetURLs:  func(fi *FileInfo) { fi.Url = "/files/" + fi.Name },
+	}
+	fi, err := UploadFileWithOptions(header, dir, options)
+	if err != nil {
+		t.Fatalf("UploadFileWithOptions(): Returned an error! [%s]", err)
+	}
+	if fi.Name != "avatar-gopher.png" || fi.Url != "/files/avatar-gopher.png" || fi.Hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("UploadFileWithOptions(): Wrong file [%+v]", fi)
+	}
+	if _, err := os.Stat(dir + string(os.PathSeparator) + fi.Name); err != nil {
+		t.Errorf("UploadFileWithOptions(): File failed to upload! [%s]", err)
+	}
+
+	errNoGophers := errors.New("no gophers")
+	var list = []struct {
+		options UploadOptions
+		err     error
+	}{
+		{options, ErrFileExists},
+		{UploadOptions{NameFunc: func(string, string) string { return "../gopher.png" }}, ErrInvalidFileName},
+		{UploadOptions{MimeTypes: []string{"image/jpeg"}}, ErrNoMatchingMimeType},
+		{UploadOptions{MaxSize: 100}, ErrFileTooLarge},
+		{UploadOptions{Validate: func(*multipart.FileHeader, string) error { return errNoGophers }}, errNoGophers},
+	}
+	for _, l := range list {
+		if _, err := UploadFileWithOptions(header, dir, l.options); err != l.err {
+			t.Errorf("UploadFileWithOptions(): Returned[%v]. Expected[%v]", err, l.err)
+		}
+	}
+
+	if files := uploadDirEntries(t, dir); len(files) != 1 {
+		t.Errorf("UploadFileWithOptions(): Refused files were saved! [%d]", len(files))
+	}
+}
+
+func TestUploadFileWithOptions_nameTaken(t *testing.T) {
+	tempDir := "testing-filevalidator"
+	dir, err := ioutil.TempDir("", tempDir) // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	// another upload saves a file with the same name while this one is scanned, after the name was checked
+	taken := dir + string(os.PathSeparator) + "taken.png"
+	DefaultScanner = scanFunc(func(r io.Reader) (ScanResult, error) {
+		ioutil.WriteFile(taken, []byte("another upload"), 0644)
+		return ScanResult{}, nil
+	})
+	defer func() { DefaultScanner = nil }()
+
+	header := setupRequestMultipartForm(&testFile{gopherPNG, "fileupload", "gopher.png"}).MultipartForm.File["fileupload"][0]
+	options := UploadOptions{NameFunc: func(string, string) string { return "taken.png" }}
+	if _, err := UploadFileWithOptions(header, dir, options); err != ErrFileExists {
+		t.Errorf("UploadFileWithOptions(): Returned[%v]. Expected[%s]", err, ErrFileExists)
+	}
+	if b, _ := ioutil.ReadFile(taken); string(b) != "another upload" {
+		t.Errorf("UploadFileWithOptions(): The file of the other upload was replaced! [%d bytes]", len(b))
+	}
+
+	// a generated name gets another UUID
+	generated := "5b4a4c9e-8a45-4cd8-9f39-6f0c7f0e1f2a.png"
+	taken = dir + string(os.PathSeparator) + generated
+	name, _, err := writeUploadedFile(dir, generated, true, strings.NewReader("this upload"))
+	if err != nil || name == generated || filepath.Ext(name) != ".png" {
+		t.Errorf("writeUploadedFile(): Returned[%s %v]. Expected a new name", name, err)
+	}
+	if b, _ := ioutil.ReadFile(taken); string(b) != "another upload" {
+		t.Errorf("writeUploadedFile(): The file of the other upload was replaced! [%d bytes]", len(b))
+	}
+	if files := uploadDirEntries(t, dir); len(files) != 3 {
+		t.Errorf("writeUploadedFile(): Returned[%d] files. Expected 3", len(files))
+	}
+}
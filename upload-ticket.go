@@ -0,0 +1,244 @@
+package fileupload
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid" // external dependencies
+	"github.com/pkg/errors"
+)
+
+var ErrTicketExpired = errors.New("The upload ticket has expired!")
+var ErrTicketSpent = errors.New("The upload ticket was already used!")
+var ErrFileTooLarge = errors.New("The file is larger than allowed!")
+var ErrTooManyFiles = errors.New("Too many files were uploaded!")
+
+/*
+	Permission to upload files without going through the application, see URLSigner.IssueTicket() and TicketUploadHandler()
+
+	Category - name of a Category of the upload endpoint, the directories are never sent to the client
+	MimeTypes - allowed types, they are also limited by the Category. Empty allows all types of the Category
+	MaxSize - bytes per file, 0 is no limit
+	MaxFiles - files in one request, 0 is one file
+	Owner - the files count towards the quota of the owner, see DefaultQuota
+	ID - random, set by IssueTicket(). See URLSigner.SpendTicket()
+*/
+type UploadTicket struct {
+	Category  string    `json:"category"`
+	MimeTypes []string  `json:"mimeTypes,omitempty"`
+	MaxSize   int64     `json:"maxSize,omitempty"`
+	MaxFiles  int       `json:"maxFiles,omitempty"`
+	Owner     string    `json:"owner,omitempty"`
+	Expires   time.Time `json:"expires"`
+	KeyID     string    `json:"kid"`
+	ID        string    `json:"id"`
+}
+
+/*
+	A signed ticket valid for ttl, sent to the client as a "ticket" parameter or an X-Upload-Ticket header
+	TicketUploadHandler() accepts each ticket once, see SpendTicket()
+*/
+func (s *URLSigner) IssueTicket(ticket UploadTicket, ttl time.Duration) (string, error) {
+	s.mu.RLock()
+	if len(s.keys) == 0 {
+		s.mu.RUnlock()
+		return "", ErrNoSigningKey
+	}
+	key := s.keys[0]
+	s.mu.RUnlock()
+
+	ticket.Expires, ticket.KeyID, ticket.ID = time.Now().Add(ttl).UTC(), key.ID, uuid.New().String()
+	payload, err := json.Marshal(ticket)
+	if err != nil {
+		return "", errors.Wrap(err, "URLSigner.IssueTicket()")
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + signTicket(key.Secret, encoded), nil
+}
+
+// check the signature and the expiry time of a ticket, returns ErrBadSignature and ErrTicketExpired
+func (s *URLSigner) ParseTicket(token string) (*UploadTicket, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrBadSignature
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrBadSignature
+	}
+
+	ticket := &UploadTicket{}
+	if err := json.Unmarshal(payload, ticket); err != nil {
+		return nil, ErrBadSignature
+	}
+	secret := s.secret(ticket.KeyID) // the key is trusted only once the signature is checked
+	if secret == nil || !hmac.Equal([]byte(parts[1]), []byte(signTicket(secret, parts[0]))) {
+		return nil, ErrBadSignature
+	}
+	if time.Now().After(ticket.Expires) {
+		return nil, ErrTicketExpired
+	}
+
+	return ticket, nil
+}
+
+/*
+	Mark a parsed ticket as used, returns ErrTicketSpent when it was used before
+	Spent tickets are kept in memory until they expire, servers sharing the keys do not know the tickets spent on the others
+*/
+func (s *URLSigner) SpendTicket(ticket *UploadTicket) error {
+	if len(ticket.ID) == 0 { // not issued by IssueTicket(), it could not be told apart from another
+		return ErrTicketSpent
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for id, expires := range s.spent {
+		if now.After(expires) { // refused by ParseTicket() from now on
+			delete(s.spent, id)
+		}
+	}
+	if _, ok := s.spent[ticket.ID]; ok {
+		return ErrTicketSpent
+	}
+	if s.spent == nil {
+		s.spent = map[string]time.Time{}
+	}
+	s.spent[ticket.ID] = ticket.Expires
+	return nil
+}
+
+func signTicket(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("upload-ticket\n" + payload)) // never the same as a signed URL
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+/*
+	The Category list of a ticket for UploadFileByCategory(), only the types allowed by both are left
+	Returns nil for a Category that is not in the list
+*/
+func (t *UploadTicket) categories(list map[string]Category) []Category {
+	category, ok := list[t.Category]
+	if !ok {
+		return nil
+	}
+	if len(t.MimeTypes) == 0 {
+		return []Category{category}
+	}
+
+	allowed := []string{}
+	for _, mimetype := range t.MimeTypes {
+		if inSlice(category.mimeTypes, mimetype) || (len(category.mimeTypes) == 1 && category.mimeTypes[0] == "*") {
+			allowed = append(allowed, mimetype)
+		}
+	}
+	return []Category{{allowed, category.directory}}
+}
+
+/*
+	Save the files of a request with a ticket, checked like UploadFileByCategory() with the types and sizes of the ticket
+	Returns one FileInfo for each file, Error is set for the files that were refused
+*/
+func UploadWithTicket(ticket *UploadTicket, files []*multipart.FileHeader, list map[string]Category) ([]FileInfo, error) {
+	categories := ticket.categories(list)
+	if categories == nil {
+		return nil, ErrNoMatchingMimeType
+	}
+	maxFiles := ticket.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = 1
+	}
+	if len(files) > maxFiles {
+		return nil, ErrTooManyFiles
+	}
+
+	options := UploadOptions{KeepExtension: true, Owner: ticket.Owner, MaxSize: ticket.MaxSize}
+	var slice []FileInfo
+	for _, header := range files {
+		fi, err := UploadFileByCategoryWithOptions(header, categories, options)
+		if err != nil {
+			if fi == nil {
+				fi = &FileInfo{OriginalName: header.Filename, Size: header.Size}
+			}
+			fi.Error = errors.Cause(err).Error()
+		}
+		slice = append(slice, *fi)
+	}
+
+	return slice, nil
+}
+
+/*
+	A small upload endpoint that needs no session, only a ticket from URLSigner.IssueTicket()
+	list - the Category of each name a ticket can use
+	Each ticket is accepted once, even when the upload fails. The request body is limited to the size allowed by the ticket before it is read
+	Example:
+	http.Handle("/direct-upload", fileupload.TicketUploadHandler(signer, map[string]fileupload.Category{"avatars": *avatars}))
+*/
+func TicketUploadHandler(signer *URLSigner, list map[string]Category) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := r.Header.Get("X-Upload-Ticket")
+		if len(token) == 0 {
+			token = r.URL.Query().Get("ticket") // the body is not read before the ticket is checked
+		}
+		ticket, err := signer.ParseTicket(token)
+		if err == nil {
+			err = signer.SpendTicket(ticket)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		maxFiles := int64(ticket.MaxFiles)
+		if maxFiles <= 0 {
+			maxFiles = 1
+		}
+		if ticket.MaxSize > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, maxFiles*ticket.MaxSize+64*1024) // room for the multipart headers
+		}
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			} else {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		var files []*multipart.FileHeader
+		for _, fieldSlice := range r.MultipartForm.File {
+			files = append(files, fieldSlice...)
+		}
+
+		fis, err := UploadWithTicket(ticket, files, list)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		b, err := json.Marshal(map[string][]FileInfo{"files": fis})
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+}
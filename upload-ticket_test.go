@@ -0,0 +1,139 @@
+package fileupload
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestURLSigner_IssueTicket(t *testing.T) {
+	signer := NewURLSigner(SigningKey{"1", []byte("a secret that is long enough for a test")})
+
+	token, err := signer.IssueTicket(UploadTicket{Category: "images", MaxSize: 1000}, time.Minute)
+	if err != nil {
+		t.Fatalf("URLSigner.IssueTicket(): Returned an error! [%s]", err)
+	}
+	ticket, err := signer.ParseTicket(token)
+	if err != nil || ticket.Category != "images" || ticket.MaxSize != 1000 {
+		t.Errorf("URLSigner.ParseTicket(): Returned[%+v %v]", ticket, err)
+	}
+
+	// a changed ticket, the payload is only base64
+	parts := strings.Split(token, ".")
+	changed, _ := json.Marshal(UploadTicket{Category: "images", MaxSize: 1 << 40, Expires: time.Now().Add(time.Hour), KeyID: "1"})
+	if _, err := signer.ParseTicket(base64.RawURLEncoding.EncodeToString(changed) + "." + parts[1]); err != ErrBadSignature {
+		t.Errorf("URLSigner.ParseTicket(): A changed ticket should return ErrBadSignature! [%v]", err)
+	}
+
+	// a signed URL is not a ticket
+	link, _ := signer.Sign("/download?file=a.jpg", time.Hour, URLBinding{})
+	if _, err := signer.ParseTicket(link); err != ErrBadSignature {
+		t.Errorf("URLSigner.ParseTicket(): A signed URL should return ErrBadSignature! [%v]", err)
+	}
+
+	expired, _ := signer.IssueTicket(UploadTicket{Category: "images"}, -time.Second)
+	if _, err := signer.ParseTicket(expired); err != ErrTicketExpired {
+		t.Errorf("URLSigner.ParseTicket(): Should return ErrTicketExpired! [%v]", err)
+	}
+
+	if err := signer.SpendTicket(ticket); err != nil {
+		t.Errorf("URLSigner.SpendTicket(): Returned an error! [%s]", err)
+	}
+	if err := signer.SpendTicket(ticket); err != ErrTicketSpent {
+		t.Errorf("URLSigner.SpendTicket(): A ticket was spent twice! [%v]", err)
+	}
+	if err := signer.SpendTicket(&UploadTicket{Category: "images", Expires: time.Now().Add(time.Hour)}); err != ErrTicketSpent {
+		t.Errorf("URLSigner.SpendTicket(): A ticket without an ID should return ErrTicketSpent! [%v]", err)
+	}
+}
+
+func TestTicketUploadHandler(t *testing.T) {
+	tempDir1, tempDir2 := "testing-filevalidator-images", "testing-filevalidator-other-uploads"
+	dir1, err := ioutil.TempDir("", tempDir1) // make two temporary directories
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	dir2, err := ioutil.TempDir("", tempDir2)
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir1) // delete the temp directories
+	defer os.RemoveAll(dir2)
+
+	signer := NewURLSigner(SigningKey{"1", []byte("a secret that is long enough for a test")})
+	handler := TicketUploadHandler(signer, map[string]Category{
+		"images": {[]string{"image/png", "image/jpeg"}, dir1},
+		"other":  {[]string{"*"}, dir2},
+	})
+
+	post := func(token string, tf ...*testFile) *httptest.ResponseRecorder {
+		body, contentType := setupHTTPRequestBody(tf...)
+		r := httptest.NewRequest("POST", "/direct-upload?ticket="+token, body)
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	files := func(w *httptest.ResponseRecorder) []FileInfo {
+		var response struct{ Files []FileInfo }
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return response.Files
+	}
+
+	pngOnly, _ := signer.IssueTicket(UploadTicket{Category: "images", MimeTypes: []string{"image/png"}, MaxSize: 20000, MaxFiles: 2}, time.Minute)
+	w := post(pngOnly, &testFile{gopherPNG, "fileupload", "gopher.png"}, &testFile{blueJPG, "fileupload", "blue.jpg"})
+	fis := files(w)
+	if w.Code != http.StatusOK || len(fis) != 2 {
+		t.Fatalf("TicketUploadHandler(): Returned[%d %s]", w.Code, w.Body.String())
+	}
+	for _, fi := range fis {
+		if fi.OriginalName == "gopher.png" && (len(fi.Error) > 0 || fi.Directory != dir1) {
+			t.Errorf("TicketUploadHandler(gopher.png): Should be saved! [%+v]", fi)
+		}
+		if fi.OriginalName == "blue.jpg" && fi.Error != ErrNoMatchingMimeType.Error() {
+			t.Errorf("TicketUploadHandler(blue.jpg): The ticket only allows png! [%+v]", fi)
+		}
+	}
+
+	// a ticket is used once
+	if w := post(pngOnly, &testFile{gopherPNG, "fileupload", "gopher.png"}); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), ErrTicketSpent.Error()) {
+		t.Errorf("TicketUploadHandler(): A used ticket returned[%d %s]. Expected 403", w.Code, w.Body.String())
+	}
+
+	// only a body larger than the ticket allows is too large, a broken body is a bad request
+	large, _ := signer.IssueTicket(UploadTicket{Category: "other", MaxSize: 100}, time.Minute)
+	if w := post(large, &testFile{base64.StdEncoding.EncodeToString(make([]byte, 100000)), "fileupload", "large.bin"}); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("TicketUploadHandler(): A body larger than the ticket returned[%d]. Expected 413", w.Code)
+	}
+	broken, _ := signer.IssueTicket(UploadTicket{Category: "other", MaxSize: 100}, time.Minute)
+	r := httptest.NewRequest("POST", "/direct-upload?ticket="+broken, strings.NewReader("not a multipart body"))
+	r.Header.Set("Content-Type", "multipart/form-data; boundary=xyz")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("TicketUploadHandler(): A broken body returned[%d]. Expected 400", w.Code)
+	}
+
+	small, _ := signer.IssueTicket(UploadTicket{Category: "other", MaxSize: 100}, time.Minute)
+	if fis := files(post(small, &testFile{carTARGZ, "fileupload", "car.tar.gz"})); len(fis) != 1 || fis[0].Error != ErrFileTooLarge.Error() {
+		t.Errorf("TicketUploadHandler(): A file larger than the ticket was accepted! [%+v]", fis)
+	}
+
+	one, _ := signer.IssueTicket(UploadTicket{Category: "other"}, time.Minute)
+	if w := post(one, &testFile{carTARGZ, "fileupload", "car.tar.gz"}, &testFile{carTARGZ, "fileupload", "car2.tar.gz"}); w.Code != http.StatusBadRequest {
+		t.Errorf("TicketUploadHandler(): Two files with a ticket for one returned[%d]. Expected 400", w.Code)
+	}
+	if w := post("", &testFile{carTARGZ, "fileupload", "car.tar.gz"}); w.Code != http.StatusForbidden {
+		t.Errorf("TicketUploadHandler(): No ticket returned[%d]. Expected 403", w.Code)
+	}
+
+	if files := uploadDirEntries(t, dir2); len(files) != 0 {
+		t.Errorf("TicketUploadHandler(): Refused files were saved! [%d]", len(files))
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"io"
 	"mime/multipart"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/google/uuid" // external dependencies
 	"github.com/pkg/errors"
@@ -19,12 +22,29 @@ var ErrNotImageType = errors.New("This file is not an image! Bad mimetype!")
 type FileInfo struct {
 	Name         string `json:"name"`
 	OriginalName string `json:"originalName,omitempty"`
+	Owner        string `json:"owner,omitempty"` // user/account the file counts for, see DefaultQuota
 	Size         int64  `json:"size"`
+	Hash         string `json:"hash,omitempty"` // hex SHA-256 of the uploaded bytes, see UploadOptions
 	IsImage      bool   `json:"-"`
 	Directory    string `json:"path"`
+	RelativePath string `json:"relativePath,omitempty"` // "2024/05/name.jpg", set by WalkDirectory()
 	MimeType     string `json:"mimeType"`
 	Width        int    `json:"width,omitempty"`
 	Height       int    `json:"height,omitempty"`
+	Frames       int    `json:"frames,omitempty"`   // animated images
+	Duration     int    `json:"duration,omitempty"` // animated images, milliseconds
+
+	ModTime *time.Time `json:"modTime,omitempty"` // see ListingDetails
+
+	BlurHash      string      `json:"blurhash,omitempty"`      // placeholders shown while the thumbnail loads
+	Placeholder   string      `json:"placeholder,omitempty"`   // tiny jpeg as a data URI
+	DominantColor string      `json:"dominantColor,omitempty"` // "#rrggbb"
+	Sanitized     bool        `json:"sanitized,omitempty"`     // active content was removed from an SVG image before saving
+	State         UploadState `json:"state,omitempty"`         // see Quarantine, set by GetDirectoryContentsData()
+	Processing    JobStatus   `json:"processing,omitempty"`    // background work on the file, see ImageOptions.Jobs
+
+	ArchivedName  string `json:"-"` // untouched original image, see ImageOptions.ArchiveDir
+	ThumbnailName string `json:"-"` // file name in the thumbnail directory
 
 	Url           string `json:"url,omitempty"`
 	ThumbnailUrl  string `json:"thumbnailUrl,omitempty"`
@@ -67,54 +87,145 @@ func SliceJSON(fis []FileInfo) (string, error) {
 
 // copy an uploaded file to a directory
 func UploadFile(header *multipart.FileHeader, directory string, includeOldExtension bool) (*FileInfo, error) {
-	if _, err := os.Stat(directory); os.IsNotExist(err) { // does the directory exist?
-		return nil, ErrDirectoryDoesNotExist
-	}
+	return UploadFileWithOwner(header, directory, "", includeOldExtension)
+}
+
+// copy an uploaded file to a directory, the file counts towards the storage quota of the owner, see DefaultQuota
+func UploadFileWithOwner(header *multipart.FileHeader, directory, owner string, includeOldExtension bool) (*FileInfo, error) {
+	return UploadFileWithOptions(header, directory, UploadOptions{KeepExtension: includeOldExtension, Owner: owner})
+}
 
-	var newName, mimetype string
-	newName = uuid.New().String() //UUIDv4
-	if includeOldExtension {
-		newName += "." + getFileExtension(header.Filename)
+/*
+	Copy the uploaded file to a created file
+	SVG images and files named .svg are sanitized, the sanitizer refuses a .svg file that is not an SVG image (ErrNotSVG)
+	generated - newName is a UUID, see writeUploadedFile()
+*/
+func copyUploadedFile(directory, newName, mimetype, oldName string, generated bool, file io.Reader) (*FileInfo, error) {
+	if mimetype == "image/svg+xml" || getFileExtension(oldName) == "svg" || getFileExtension(newName) == "svg" { // scripts in SVG images are removed
+		return saveSVG(file, directory, newName, oldName, generated)
 	}
 
-	file, err := header.Open()
+	name, size, err := writeUploadedFile(directory, newName, generated, file)
+	if infected, ok := err.(*InfectedError); ok {
+		return infectedFileInfo(infected, newName, oldName, mimetype), infected
+	}
+	if err == ErrFileExists {
+		return nil, err
+	}
 	if err != nil {
-		return nil, errors.Wrap(err, "UploadFile()")
+		return nil, errors.Wrap(err, "copyUploadedFile()")
 	}
-	defer file.Close()
 
-	if mimetype, err = getMimeType(file); err != nil {
-		return nil, errors.Wrap(err, "UploadFile()")
+	return &FileInfo{Name: name, OriginalName: oldName, Size: size, IsImage: isFileImage(mimetype), Directory: directory, MimeType: mimetype}, nil
+}
+
+/*
+	Same as copyUploadedFile(), the quota of the owner is checked before the copy and while the file is copied
+	size - from the multipart header, the real size is only known once the file is copied
+*/
+func copyOwnedFile(directory, newName, mimetype, oldName, owner string, generated bool, size int64, file io.Reader) (*FileInfo, error) {
+	if err := checkQuota(owner, size); err != nil {
+		return nil, err
 	}
 
-	f, err := os.OpenFile(directory+string(os.PathSeparator)+newName, os.O_WRONLY|os.O_CREATE, 0644) // create a file
+	reader := newQuotaReader(owner, file)
+	fi, err := copyUploadedFile(directory, newName, mimetype, oldName, generated, reader)
 	if err != nil {
-		return nil, errors.Wrapf(err, "UploadFile() Filename[%s]", directory+newName)
+		reader.settle(0)
+		if errors.Cause(err) == ErrQuotaExceeded { // aborted during the copy
+			return nil, ErrQuotaExceeded
+		}
+		return fi, err
 	}
-	defer f.Close() // close created file
 
-	size, err := io.Copy(f, file) // copy the uploaded file to the created file
-	if err != nil {
-		return nil, errors.Wrap(err, "UploadFile()")
+	if err := reader.settle(fi.Size); err != nil {
+		removeFromDirectory(directory, fi.Name)
+		reader.settle(0)
+		return nil, err
+	}
+	if err := recordOwner(fi, owner); err != nil {
+		removeFromDirectory(directory, fi.Name)
+		reader.settle(0)
+		return nil, errors.Wrap(err, "copyOwnedFile()")
 	}
 
-	return &FileInfo{Name: newName, OriginalName: header.Filename, Size: size, IsImage: isFileImage(mimetype), Directory: directory, MimeType: mimetype}, nil
+	return fi, nil
 }
 
-// copy the uploaded file to a created file
-func copyUploadedFile(directory, newName, mimetype, oldName string, file multipart.File) (*FileInfo, error) {
-	f, err := os.OpenFile(directory+string(os.PathSeparator)+newName, os.O_WRONLY|os.O_CREATE, 0644) // create a file
+// hidden directory inside an upload directory for files that are still being copied, listings skip it
+const stagingDirName = ".uploading"
+
+/*
+	Write a file to a temporary name in the staging directory, scan it with DefaultScanner and give it its final name
+	A file that is only partly copied or infected never shows up in the directory, not even under its temporary name
+	An existing file is never replaced, a taken generated name (a UUID) gets a new UUID and other names return ErrFileExists
+	Returns the name of the saved file
+*/
+func writeUploadedFile(directory, newName string, generated bool, r io.Reader) (string, int64, error) {
+	root, err := OpenRoot(directory)
 	if err != nil {
-		return nil, errors.Wrapf(err, "copyUploadedFile() Filename[%s]", directory+newName)
+		return "", 0, errors.Wrap(err, "writeUploadedFile()")
 	}
-	defer f.Close() // close created file
+	defer root.Close()
 
-	size, err := io.Copy(f, file) // copy the uploaded file to the created file
+	if err := root.MkdirAll(stagingDirName, 0755); err != nil {
+		return "", 0, errors.Wrap(err, "writeUploadedFile()")
+	}
+	f, err := root.CreateTemp(stagingDirName, ".upload-") // create a file
 	if err != nil {
-		return nil, errors.Wrap(err, "copyUploadedFile()")
+		return "", 0, errors.Wrapf(err, "writeUploadedFile() Filename[%s]", directory+newName)
+	}
+	tempName := filepath.Join(stagingDirName, filepath.Base(f.Name()))
+
+	size, err := io.Copy(f, r) // copy the uploaded file to the created file
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		root.Remove(tempName)
+		return "", 0, errors.Wrap(err, "writeUploadedFile()")
+	}
+
+	if err := scanTempFile(root, tempName, newName); err != nil {
+		return "", 0, err
+	}
+
+	name, err := publishFile(root, tempName, newName, generated)
+	root.Remove(tempName) // the file is kept under its new name
+	if err == ErrFileExists {
+		return "", 0, err
+	}
+	if err != nil {
+		return "", 0, errors.Wrap(err, "writeUploadedFile()")
 	}
 
-	return &FileInfo{Name: newName, OriginalName: oldName, Size: size, IsImage: isFileImage(mimetype), Directory: directory, MimeType: mimetype}, nil
+	return name, size, nil
+}
+
+// give a staged file its name with a hard link, unlike a rename it fails when another upload took the name in the meantime
+func publishFile(root *Root, tempName, name string, generated bool) (string, error) {
+	for i := 0; ; i++ {
+		err := root.Link(tempName, name)
+		if !os.IsExist(err) {
+			return name, err
+		}
+		if !generated || i == 10 {
+			return "", ErrFileExists
+		}
+		name = newGeneratedName(name)
+	}
+}
+
+// another UUID for a generated name, the extension is kept
+func newGeneratedName(name string) string {
+	ext := ""
+	if i := strings.IndexByte(name, '.'); i >= 0 { // a UUID has no dots
+		ext = name[i:]
+	}
+	return uuid.New().String() + ext
 }
 
 /*
@@ -126,26 +237,21 @@ func copyUploadedFile(directory, newName, mimetype, oldName string, file multipa
 	uploadFileByCategory(header, []Category{ Category{ []string{"*"}, "uploads-directory"} })
 */
 func UploadFileByCategory(header *multipart.FileHeader, list []Category, includeOldExtension bool) (*FileInfo, error) {
-	var newName, mimetype, leftoverTypesDirectory string
-
-	newName = uuid.New().String() //UUIDv4
-	if includeOldExtension {
-		newName += "." + getFileExtension(header.Filename)
-	}
+	return UploadFileByCategoryWithOwner(header, list, "", includeOldExtension)
+}
 
-	file, err := header.Open()
-	if err != nil {
-		return nil, errors.Wrap(err, "UploadFileByCategory()")
-	}
-	defer file.Close()
+// same as UploadFileByCategory(), the file counts towards the storage quota of the owner, see DefaultQuota
+func UploadFileByCategoryWithOwner(header *multipart.FileHeader, list []Category, owner string, includeOldExtension bool) (*FileInfo, error) {
+	return UploadFileByCategoryWithOptions(header, list, UploadOptions{KeepExtension: includeOldExtension, Owner: owner})
+}
 
-	if mimetype, err = getMimeType(file); err != nil {
-		return nil, errors.Wrap(err, "UploadFileByCategory()")
-	}
+// find the directory of the Category for a mimetype
+func categoryDirectory(list []Category, mimetype string) (string, error) {
+	var leftoverTypesDirectory string
 
 	for _, l := range list { // loop through the Category list
 		if _, err := os.Stat(l.directory); os.IsNotExist(err) { // all supplied directories must exist
-			return nil, ErrDirectoryDoesNotExist
+			return "", ErrDirectoryDoesNotExist
 		}
 
 		if len(l.mimeTypes) == 1 && l.mimeTypes[0] == "*" { // look for the left-over/backup type
@@ -153,45 +259,21 @@ func UploadFileByCategory(header *multipart.FileHeader, list []Category, include
 		}
 
 		if inSlice(l.mimeTypes, mimetype) { // found a mimetype in a Category
-			return copyUploadedFile(l.directory, newName, mimetype, header.Filename, file)
+			return l.directory, nil
 		}
 	}
 
 	if len(leftoverTypesDirectory) > 0 { // no mimetype matched, use the backup directory
-		return copyUploadedFile(leftoverTypesDirectory, newName, mimetype, header.Filename, file)
+		return leftoverTypesDirectory, nil
 	}
 
-	return nil, ErrNoMatchingMimeType
+	return "", ErrNoMatchingMimeType
 }
 
 func UploadAllFiles(files map[string][]*multipart.FileHeader, directory string, includeOldExtension bool) ([]FileInfo, error) {
-	var slice []FileInfo
-
-	for _, fieldSlice := range files {
-		for _, header := range fieldSlice {
-			fi, err := UploadFile(header, directory, includeOldExtension)
-			if err != nil {
-				return nil, errors.Wrap(err, "UploadAllFiles()")
-			}
-			slice = append(slice, *fi)
-		}
-	}
-
-	return slice, nil
+	return UploadAllFilesWithOptions(files, directory, UploadOptions{KeepExtension: includeOldExtension})
 }
 
 func UploadAllFilesByCategory(files map[string][]*multipart.FileHeader, list []Category, includeOldExtension bool) ([]FileInfo, error) {
-	var slice []FileInfo
-
-	for _, fieldSlice := range files {
-		for _, header := range fieldSlice {
-			fi, err := UploadFileByCategory(header, list, includeOldExtension)
-			if err != nil {
-				return nil, errors.Wrap(err, "UploadAllFilesByCategory()")
-			}
-			slice = append(slice, *fi)
-		}
-	}
-
-	return slice, nil
+	return UploadAllFilesByCategoryWithOptions(files, list, UploadOptions{KeepExtension: includeOldExtension})
 }
@@ -149,3 +149,19 @@ func TestUploadAllFilesByCategory(t *testing.T) {
 		}
 	}
 }
+
+// the entries of an upload directory without the staging directory, which has to be empty
+func uploadDirEntries(t *testing.T, dir string) []os.FileInfo {
+	if staged, _ := ioutil.ReadDir(dir + string(os.PathSeparator) + stagingDirName); len(staged) > 0 {
+		t.Errorf("%s: %d temporary files were left in the staging directory", dir, len(staged))
+	}
+
+	entries, _ := ioutil.ReadDir(dir)
+	files := entries[:0]
+	for _, fi := range entries {
+		if fi.Name() != stagingDirName {
+			files = append(files, fi)
+		}
+	}
+	return files
+}
@@ -0,0 +1,119 @@
+package fileupload
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/*
+	Builds the Url, ThumbnailUrl, DeleteUrl, DeleteNoJSUrl and DeleteMethod of saved and listed files
+
+	CDNHost - scheme and host in front of Url and ThumbnailUrl ("https://cdn.example.com"), empty keeps them relative to the site
+	ThumbnailURL - pattern of thumbnail URLs, "{name}" is replaced by FileInfo.ThumbnailName ("/thumbnails/{name}")
+	DeleteURL - pattern of the delete route, "{name}" is replaced by the file name ("/delete?file={name}"), see DeleteHandler()
+	DeleteMethod - "DELETE" when empty. DeleteNoJSUrl is DeleteURL with _method=DELETE, for forms that can only POST
+
+	The base URL of each directory is set with SetBaseURL(), files in subdirectories get the path below the closest directory with one
+	Files in a directory without a base URL only get the delete URLs
+	Example:
+	builder := fileupload.NewURLBuilder()
+	builder.SetBaseURL("/srv/uploads/images", "/images")
+	builder.ThumbnailURL, builder.DeleteURL = "/thumbnails/{name}", "/delete?file={name}"
+	fileupload.DefaultURLBuilder = builder
+*/
+type URLBuilder struct {
+	CDNHost      string
+	ThumbnailURL string
+	DeleteURL    string
+	DeleteMethod string
+
+	mu       sync.RWMutex
+	baseURLs map[string]string // by cleaned directory
+}
+
+/*
+	Used by the upload and listing functions when their SetURLs option is nil, nil sets no URLs
+	The URLs can be signed afterwards with URLSigner.SignFileInfo()
+*/
+var DefaultURLBuilder *URLBuilder
+
+func NewURLBuilder() *URLBuilder {
+	return &URLBuilder{baseURLs: map[string]string{}}
+}
+
+// the public URL of a directory ("/images"), the directory as it is passed to the upload functions or a Category
+func (b *URLBuilder) SetBaseURL(directory, baseURL string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.baseURLs == nil {
+		b.baseURLs = map[string]string{}
+	}
+	b.baseURLs[filepath.Clean(directory)] = strings.TrimSuffix(baseURL, "/")
+}
+
+// set the URLs of a file, can be used as the SetURLs option of uploads and listings
+func (b *URLBuilder) Set(fi *FileInfo) {
+	if base, ok := b.baseURL(fi.Directory); ok {
+		fi.Url = b.CDNHost + base + "/" + url.PathEscape(fi.Name)
+	}
+	if len(b.ThumbnailURL) > 0 && len(fi.ThumbnailName) > 0 {
+		fi.ThumbnailUrl = b.CDNHost + fillURLPattern(b.ThumbnailURL, fi.ThumbnailName)
+	}
+
+	if len(b.DeleteURL) > 0 {
+		fi.DeleteUrl = fillURLPattern(b.DeleteURL, fi.Name)
+		fi.DeleteMethod = b.DeleteMethod
+		if len(fi.DeleteMethod) == 0 {
+			fi.DeleteMethod = "DELETE"
+		}
+		separator := "?"
+		if strings.Contains(fi.DeleteUrl, "?") {
+			separator = "&"
+		}
+		fi.DeleteNoJSUrl = fi.DeleteUrl + separator + "_method=" + url.QueryEscape(fi.DeleteMethod)
+	}
+}
+
+// the base URL of a directory, or of the closest parent with one and the path below it
+func (b *URLBuilder) baseURL(directory string) (string, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var below []string
+	for dir := filepath.Clean(directory); ; dir = filepath.Dir(dir) {
+		if base, ok := b.baseURLs[dir]; ok {
+			for i := len(below) - 1; i >= 0; i-- {
+				base += "/" + url.PathEscape(below[i])
+			}
+			return base, true
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return "", false
+		}
+		below = append(below, filepath.Base(dir))
+	}
+}
+
+// replace "{name}" by the escaped name, in the query string when it is after the "?"
+func fillURLPattern(pattern, name string) string {
+	query := strings.Index(pattern, "?")
+	at := strings.Index(pattern, "{name}")
+	if at < 0 {
+		return pattern
+	}
+	if query >= 0 && at > query {
+		return strings.Replace(pattern, "{name}", url.QueryEscape(name), 1)
+	}
+	return strings.Replace(pattern, "{name}", url.PathEscape(name), 1)
+}
+
+// fill in the URLs of a file with setURLs, or with DefaultURLBuilder when it is nil
+func setFileURLs(fi *FileInfo, setURLs func(fi *FileInfo)) {
+	if setURLs != nil {
+		setURLs(fi)
+	} else if DefaultURLBuilder != nil {
+		DefaultURLBuilder.Set(fi)
+	}
+}
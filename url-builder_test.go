@@ -0,0 +1,70 @@
+package fileupload
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestURLBuilder_Set(t *testing.T) {
+	builder := NewURLBuilder()
+	builder.SetBaseURL("/srv/uploads/images/", "/images/")
+	builder.CDNHost = "https://cdn.example.com"
+	builder.ThumbnailURL, builder.DeleteURL = "/thumbnails/{name}", "/delete?file={name}"
+
+	var list = []struct {
+		fi                      FileInfo
+		link, thumbnail, delete string
+	}{
+		{FileInfo{Name: "a b.jpg", Directory: "/srv/uploads/images", ThumbnailName: "a b.jpg"}, "https://cdn.example.com/images/a%20b.jpg", "https://cdn.example.com/thumbnails/a%20b.jpg", "/delete?file=a+b.jpg"},
+		{FileInfo{Name: "c.png", Directory: "/srv/uploads/images/2026/10"}, "https://cdn.example.com/images/2026/10/c.png", "", "/delete?file=c.png"},
+		{FileInfo{Name: "d&e.txt", Directory: "/srv/private"}, "", "", "/delete?file=d%26e.txt"},
+	}
+	for _, l := range list {
+		fi := l.fi
+		builder.Set(&fi)
+		if fi.Url != l.link || fi.ThumbnailUrl != l.thumbnail || fi.DeleteUrl != l.delete || fi.DeleteMethod != "DELETE" {
+			t.Errorf("URLBuilder.Set(%s): Returned[%s %s %s %s]. Expected[%s %s %s DELETE]", fi.Name, fi.Url, fi.ThumbnailUrl, fi.DeleteUrl, fi.DeleteMethod, l.link, l.thumbnail, l.delete)
+		}
+		if fi.DeleteNoJSUrl != l.delete+"&_method=DELETE" {
+			t.Errorf("URLBuilder.Set(%s): Wrong DeleteNoJSUrl [%s]", fi.Name, fi.DeleteNoJSUrl)
+		}
+	}
+}
+
+func TestDefaultURLBuilder(t *testing.T) {
+	tempDir := "testing-filevalidator"
+	dir, err := ioutil.TempDir("", tempDir) // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	DefaultURLBuilder = NewURLBuilder()
+	DefaultURLBuilder.SetBaseURL(dir, "/files")
+	defer func() { DefaultURLBuilder = nil }()
+
+	req := setupRequestMultipartForm(&testFile{carTARGZ, "fileupload", "car.tar.gz"})
+	fi, err := UploadFile(req.MultipartForm.File["fileupload"][0], dir, true)
+	if err != nil {
+		t.Fatalf("UploadFile(): Returned an error! [%s]", err)
+	}
+	if fi.Url != "/files/"+fi.Name {
+		t.Errorf("UploadFile(): Url not set by DefaultURLBuilder [%s]", fi.Url)
+	}
+
+	fis, err := GetDirectoryContentsData(dir, false)
+	if err != nil {
+		t.Fatalf("GetDirectoryContentsData(): Returned an error! [%s]", err)
+	}
+	if len(fis) != 1 || fis[0].Url != "/files/"+fi.Name {
+		t.Errorf("GetDirectoryContentsData(): Url not set by DefaultURLBuilder [%+v]", fis)
+	}
+
+	// the SetURLs option comes first
+	fis, err = WalkDirectory(filepath.Join(dir, "."), WalkOptions{Details: ListingDetails{SetURLs: func(fi *FileInfo) { fi.Url = "custom" }}})
+	if err != nil || len(fis) != 1 || fis[0].Url != "custom" {
+		t.Errorf("WalkDirectory(): The SetURLs option was not used [%+v %v]", fis, err)
+	}
+}
@@ -10,7 +10,7 @@ import (
 
 func isFileImage(mimetype string) bool {
 	mimetype = strings.ToLower(mimetype) // make it case insensitive
-	var types []string = []string{"image/jpeg", "image/png", "image/gif"}
+	var types []string = []string{"image/jpeg", "image/png", "image/gif", "image/webp", "image/bmp", "image/tiff", "image/heic", "image/heif", "image/svg+xml"}
 	return inSlice(types, mimetype)
 }
 
@@ -21,7 +21,8 @@ func getMimeType(file multipart.File) (string, error) {
 		return "", errors.Wrap(err, "getMimeType()")
 	}
 
-	if n, err := file.Read(buffer); n <= 0 && err != nil { // Copy bytes into a buffer
+	n, err := file.Read(buffer)
+	if n <= 0 && err != nil { // Copy bytes into a buffer
 		return "", errors.Wrap(err, "getMimeType()")
 	}
 
@@ -29,6 +30,15 @@ func getMimeType(file multipart.File) (string, error) {
 		return "", errors.Wrap(err, "getMimeType()")
 	}
 
+	if mimetype := sniffImageType(buffer[:n]); len(mimetype) > 0 { // TIFF and HEIC images
+		return mimetype, nil
+	}
+	if svg, err := sniffSVG(file); err != nil { // the whole document up to the root element
+		return "", errors.Wrap(err, "getMimeType()")
+	} else if svg {
+		return "image/svg+xml", nil
+	}
+
 	// http.DetectContentType() `always returns a valid MIME type: if it cannot determine a more specific one, it returns "application/octet-stream"`
 	return strings.TrimSpace(strings.ToLower(http.DetectContentType(buffer))), nil
 }
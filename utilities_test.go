@@ -19,7 +19,10 @@ func Test_isFileImage(t *testing.T) {
 		{"image/jpeg", true},
 		{"image/gif", true},
 		{"image/png", true},
-		{"image/webp", false},
+		{"image/webp", true},
+		{"image/tiff", true},
+		{"image/heic", true},
+		{"image/svg+xml", true},
 		{"imAGe/JPeG", true},
 		{"application/octet-stream", false},
 		{"application/zip", false},
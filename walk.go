@@ -0,0 +1,168 @@
+package fileupload
+
+import (
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors" // external dependency
+)
+
+/*
+	Options for WalkDirectory() and SummarizeDirectory()
+
+	MaxDepth - levels of subdirectories to enter, 0 is only the directory itself, a negative number is no limit
+	IncludeMimeType - read the first bytes of each file, always done by SummarizeDirectory(). Files that can not be read have Error set
+	States - only files in one of these states, see Quarantine. Empty is all files. A file whose record can not be read is listed with the Error
+	Details - image sizes, times and thumbnails, see ListingDetails
+*/
+type WalkOptions struct {
+	MaxDepth        int
+	IncludeMimeType bool
+	States          []UploadState
+	Details         ListingDetails
+}
+
+/*
+	Information about the files in a directory and its subdirectories, for date-partitioned or Category-based layouts
+	Directory of each FileInfo is the subdirectory of the file, RelativePath the path of the file from the walked directory
+	Hidden files and directories (names starting with a dot) are skipped, symbolic links to directories are not followed
+*/
+func WalkDirectory(directory string, options WalkOptions) ([]FileInfo, error) {
+	var fis []FileInfo
+	err := walkDirectory(directory, options, func(dir string, files []FileInfo) {
+		fis = append(fis, files...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fis, nil
+}
+
+// the files of one directory of a summary
+type DirectorySummary struct {
+	Path      string                  `json:"path"` // relative to the summarized directory, "." for the directory itself
+	Files     int                     `json:"files"`
+	Bytes     int64                   `json:"bytes"`
+	MimeTypes map[string]*TypeSummary `json:"mimeTypes"`
+}
+
+// the files of one mimetype, without parameters ("text/plain")
+type TypeSummary struct {
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// the totals of a directory tree and each directory in it, see SummarizeDirectory()
+type TreeSummary struct {
+	Total       DirectorySummary   `json:"total"`
+	Directories []DirectorySummary `json:"directories"` // sorted by Path
+}
+
+/*
+	File counts, bytes and mimetypes of a directory tree for admin dashboards
+	Each directory only counts its own files, Total counts all of them. Empty directories are included
+*/
+func SummarizeDirectory(directory string, options WalkOptions) (*TreeSummary, error) {
+	options.IncludeMimeType = true
+
+	summary := &TreeSummary{Total: DirectorySummary{Path: ".", MimeTypes: map[string]*TypeSummary{}}}
+	err := walkDirectory(directory, options, func(dir string, files []FileInfo) {
+		rel, _ := filepath.Rel(directory, dir)
+		d := DirectorySummary{Path: filepath.ToSlash(rel), MimeTypes: map[string]*TypeSummary{}}
+		for _, fi := range files {
+			d.add(fi)
+			summary.Total.add(fi)
+		}
+		summary.Directories = append(summary.Directories, d)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(summary.Directories, func(i, j int) bool { return summary.Directories[i].Path < summary.Directories[j].Path })
+	return summary, nil
+}
+
+func (d *DirectorySummary) add(fi FileInfo) {
+	mediatype, _, err := mime.ParseMediaType(fi.MimeType)
+	if err != nil {
+		mediatype = fi.MimeType
+	}
+	t, ok := d.MimeTypes[mediatype]
+	if !ok {
+		t = &TypeSummary{}
+		d.MimeTypes[mediatype] = t
+	}
+
+	d.Files, d.Bytes = d.Files+1, d.Bytes+fi.Size
+	t.Files, t.Bytes = t.Files+1, t.Bytes+fi.Size
+}
+
+// call visit once for each directory with its files, names are checked against the walked directory so nothing outside is read
+func walkDirectory(directory string, options WalkOptions, visit func(dir string, files []FileInfo)) error {
+	root, err := OpenRoot(directory)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	return walkRoot(root, directory, ".", 0, options, visit)
+}
+
+func walkRoot(root *Root, directory, rel string, depth int, options WalkOptions, visit func(dir string, files []FileInfo)) error {
+	entries, err := root.ReadDir(rel)
+	if err != nil {
+		return errors.Wrap(err, "WalkDirectory()")
+	}
+
+	dir := filepath.Join(directory, rel)
+	var files []FileInfo
+	var listed []listedFile
+	var subdirectories []string
+	for _, fi := range entries {
+		if strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		name := filepath.Join(rel, fi.Name())
+		if fi.IsDir() {
+			subdirectories = append(subdirectories, name)
+			continue
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if fi, err = root.Stat(name); err != nil || fi.IsDir() { // outside of the directory, to nothing, or a directory that could loop
+				continue
+			}
+		}
+
+		state, err := fileState(root, rel, fi.Name())
+		if err == nil && len(options.States) > 0 && !inStates(options.States, state) {
+			continue
+		}
+
+		file := FileInfo{Name: fi.Name(), RelativePath: filepath.ToSlash(name), Size: fi.Size(), Directory: dir, State: state}
+		if err != nil { // an unreadable record, the file is listed with the error
+			file.Error = errors.Cause(err).Error()
+		}
+		files = append(files, file)
+		listed = append(listed, listedFile{name, fi})
+	}
+	if options.IncludeMimeType {
+		sniffMimeTypes(root, listed, files)
+	}
+	if err := options.Details.fill(root, listed, files); err != nil {
+		return err
+	}
+	visit(dir, files)
+
+	if options.MaxDepth >= 0 && depth >= options.MaxDepth {
+		return nil
+	}
+	for _, name := range subdirectories {
+		if err := walkRoot(root, directory, name, depth+1, options, visit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
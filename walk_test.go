@@ -0,0 +1,92 @@
+package fileupload
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWalkDirectory(t *testing.T) {
+	tempDir := "testing-filevalidator"
+	dir, err := ioutil.TempDir("", tempDir) // make a temp directory
+	if err != nil {
+		t.Fatalf("ioutil.TempDir: %s", err)
+	}
+	defer os.RemoveAll(dir) // delete the temp directory
+
+	png, _ := base64.StdEncoding.DecodeString(gopherPNG)
+	files := map[string][]byte{
+		"top.txt":               []byte("top level file"),
+		"2026/a.png":            png,
+		"2026/10/b.txt":         []byte("a file two levels down"),
+		"2026/10/19/c.png":      png,
+		"2026/.hidden/d.txt":    []byte("hidden directory"),
+		"images/.upload-123456": []byte("hidden file"),
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("os.MkdirAll: %s", err)
+		}
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatalf("ioutil.WriteFile: %s", err)
+		}
+	}
+	os.Symlink(filepath.Join(dir, "2026"), filepath.Join(dir, "2026", "10", "loop")) // not followed
+	os.Symlink(filepath.Join("..", "top.txt"), filepath.Join(dir, "images", "link.txt")) // relative, links with an absolute target are refused
+
+	var list = []struct {
+		depth int
+		paths []string
+	}{
+		{0, []string{"top.txt"}},
+		{1, []string{"top.txt", "2026/a.png", "images/link.txt"}},
+		{-1, []string{"top.txt", "2026/a.png", "2026/10/b.txt", "2026/10/19/c.png", "images/link.txt"}},
+	}
+	for _, l := range list {
+		fis, err := WalkDirectory(dir, WalkOptions{MaxDepth: l.depth, IncludeMimeType: true})
+		if err != nil {
+			t.Errorf("WalkDirectory(%d): Returned an error! [%s]", l.depth, err)
+			continue
+		}
+		if len(fis) != len(l.paths) {
+			t.Errorf("WalkDirectory(%d): Returned[%d] files. Expected[%d] %+v", l.depth, len(fis), len(l.paths), fis)
+			continue
+		}
+		for _, fi := range fis {
+			if !inSlice(l.paths, fi.RelativePath) {
+				t.Errorf("WalkDirectory(%d): Unexpected file [%s]", l.depth, fi.RelativePath)
+			}
+			if _, err := os.Stat(filepath.Join(fi.Directory, fi.Name)); err != nil {
+				t.Errorf("WalkDirectory(%d): Directory and Name do not point to the file! [%s]", l.depth, err)
+			}
+			if filepath.Ext(fi.Name) == ".png" && (fi.MimeType != "image/png" || !fi.IsImage) {
+				t.Errorf("WalkDirectory(%d): Wrong mimetype [%s %s]", l.depth, fi.RelativePath, fi.MimeType)
+			}
+		}
+	}
+
+	summary, err := SummarizeDirectory(dir, WalkOptions{MaxDepth: -1})
+	if err != nil {
+		t.Fatalf("SummarizeDirectory(): Returned an error! [%s]", err)
+	}
+	if summary.Total.Files != 5 || summary.Total.MimeTypes["image/png"].Files != 2 || summary.Total.MimeTypes["image/png"].Bytes != 2*int64(len(png)) {
+		t.Errorf("SummarizeDirectory(): Wrong total [%+v]", summary.Total)
+	}
+	var paths []string
+	for _, d := range summary.Directories {
+		paths = append(paths, d.Path)
+	}
+	if len(paths) != 5 || paths[0] != "." || paths[1] != "2026" || paths[2] != "2026/10" || paths[3] != "2026/10/19" || paths[4] != "images" {
+		t.Errorf("SummarizeDirectory(): Wrong directories %v", paths)
+	}
+	if d := summary.Directories[2]; d.Files != 1 || d.Bytes != int64(len(files["2026/10/b.txt"])) {
+		t.Errorf("SummarizeDirectory(): Wrong summary of 2026/10 [%+v]", d)
+	}
+
+	if _, err := WalkDirectory(filepath.Join(dir, "missing"), WalkOptions{}); err != ErrDirectoryDoesNotExist {
+		t.Errorf("WalkDirectory(): Should return ErrDirectoryDoesNotExist! [%v]", err)
+	}
+}